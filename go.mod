@@ -3,12 +3,12 @@ module github.com/max-planck-innovation-competition/go-openalex
 go 1.21.0
 
 require (
+	github.com/dustin/go-humanize v1.0.1
 	github.com/glebarez/sqlite v1.11.0
 	github.com/json-iterator/go v1.1.12
 )
 
 require (
-	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/elastic/elastic-transport-go/v8 v8.6.0 // indirect
 	github.com/glebarez/go-sqlite v1.21.2 // indirect
 	github.com/go-logr/logr v1.4.1 // indirect
@@ -11,6 +11,8 @@ import (
 	"github.com/elastic/go-elasticsearch/v8/esutil"
 	"github.com/max-planck-innovation-competition/go-openalex/pkg/openalex"
 	"log/slog"
+	"os"
+	"os/signal"
 	"strings"
 	"time"
 )
@@ -303,6 +305,37 @@ func ElasticLineHandler(filePath string, line string) error {
 	return nil
 }
 
+// bulkIndexerStats returns the stats of the bulk indexers as metrics
+func bulkIndexerStats() (samples []openalex.MetricSample) {
+	for entityType, bulkIndexer := range indexers {
+		s := bulkIndexer.Stats()
+		labels := map[string]string{"entity_type": string(entityType)}
+		for _, stat := range []struct {
+			name  string
+			help  string
+			value uint64
+		}{
+			{"added", "Documents added to the bulk indexer.", s.NumAdded},
+			{"flushed", "Documents flushed to elasticsearch.", s.NumFlushed},
+			{"failed", "Documents that failed.", s.NumFailed},
+			{"indexed", "Documents that were indexed.", s.NumIndexed},
+			{"created", "Documents that were created.", s.NumCreated},
+			{"updated", "Documents that were updated.", s.NumUpdated},
+			{"deleted", "Documents that were deleted.", s.NumDeleted},
+			{"requests", "Bulk requests that were sent.", s.NumRequests},
+		} {
+			samples = append(samples, openalex.MetricSample{
+				Name:   "openalex_es_bulk_indexer_" + stat.name + "_total",
+				Help:   stat.help,
+				Type:   "counter",
+				Labels: labels,
+				Value:  float64(stat.value),
+			})
+		}
+	}
+	return samples
+}
+
 func main() {
 
 	openAlexDir := env.FallbackEnvVariable("OPENALEX_DIR", "/media/seb/T18-1/openalex-data/data")
@@ -323,17 +356,39 @@ func main() {
 		createBulkIndexer(entityType)
 	}
 
+	// stop at the next line on ctrl+c, so that the bulk indexers are still flushed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// serve the metrics, e.g. on localhost:9090/metrics
+	metrics := openalex.NewMetrics()
+	metrics.AddCollector(bulkIndexerStats)
+	if metricsAddr := env.FallbackEnvVariable("METRICS_ADDR", ""); metricsAddr != "" {
+		go metrics.ListenAndServe(ctx, metricsAddr)
+	}
+
 	p := openalex.Processor{
 		DirectoryPath:   openAlexDir,
 		StateHandler:    nil,
 		LineHandler:     ElasticLineHandler,
 		MergedIdHandler: nil,
+		Metrics:         metrics,
 	}
 
-	err := p.ProcessDirectory()
+	report, err := p.ProcessDirectoryContext(ctx)
 	if err != nil {
 		slog.With("err", err).Error("error processing directory")
 	}
+	if report != nil {
+		for _, entity := range report.Entities {
+			slog.
+				With("entityType", entity.EntityType).
+				With("files", entity.Files).
+				With("lines", entity.Lines).
+				With("failedFiles", entity.FailedFiles).
+				Info("processed entity type")
+		}
+	}
 
 	for _, entityType := range entityTypes {
 		bulkIndexer := indexers[entityType]
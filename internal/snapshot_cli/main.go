@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/SbstnErhrdt/env"
+	"github.com/max-planck-innovation-competition/go-openalex/pkg/openalex"
+)
+
+const usage = `usage: snapshot_cli <command> [flags]
+
+commands:
+  plan    shows the partitions a sync would add, replace and delete
+  sync    syncs the snapshot into the destination directory
+  verify  counts the records of the local files and compares them with the manifests,
+          exits with 1 if any file does not match
+  diff    compares the manifests of two local snapshots and prints the changes as json
+`
+
+// snapshotFlags registers the flags that configure the downloader
+func snapshotFlags(fs *flag.FlagSet) func() *openalex.SnapshotDownloader {
+	dest := fs.String("dest", env.FallbackEnvVariable("OPENALEX_SNAPSHOT_DIR", "."), "destination directory of the snapshot")
+	baseUrl := fs.String("base-url", openalex.DefaultSnapshotBaseUrl, "base url of the snapshot bucket")
+	workers := fs.Int("workers", 4, "number of parallel downloads")
+	entities := fs.String("entities", "", "comma separated list of entity types, e.g. works,authors (default all)")
+	from := fs.String("from", "", "inclusive lower bound of the updated_date partitions, e.g. 2024-01-01")
+	to := fs.String("to", "", "inclusive upper bound of the updated_date partitions")
+	skipMergedIds := fs.Bool("skip-merged-ids", false, "do not sync the merged ids files")
+	timeout := fs.Duration("timeout", 60*time.Second, "timeout of manifest and listing requests")
+	idleTimeout := fs.Duration("idle-timeout", 60*time.Second, "retries a download if no bytes arrive for this duration")
+	retries := fs.Uint64("retries", 5, "maximal number of retries of failed requests")
+	userAgent := fs.String("user-agent", openalex.DefaultUserAgent, "user agent of the requests")
+	return func() *openalex.SnapshotDownloader {
+		d := openalex.NewSnapshotDownloader(*dest)
+		d.BaseUrl = *baseUrl
+		d.Timeout = *timeout
+		d.IdleTimeout = *idleTimeout
+		d.MaxRetries = *retries
+		d.UserAgent = *userAgent
+		d.Workers = *workers
+		d.Options = openalex.SyncOptions{
+			UpdatedDateFrom: *from,
+			UpdatedDateTo:   *to,
+			SkipMergedIds:   *skipMergedIds,
+		}
+		if *entities != "" {
+			entityTypes, err := parseEntityTypes(*entities)
+			if err != nil {
+				slog.With("err", err).Error("unsupported entity type")
+				os.Exit(2)
+			}
+			d.Options.EntityTypes = entityTypes
+		}
+		return d
+	}
+}
+
+// parseEntityTypes parses a comma separated list of the directory names of the entity types, e.g. works,authors
+func parseEntityTypes(entities string) (entityTypes []openalex.FileEntityType, err error) {
+	validNames := make([]string, 0, len(openalex.ApiEntityTypes))
+	byName := make(map[string]openalex.FileEntityType, len(openalex.ApiEntityTypes))
+	for _, entityType := range openalex.ApiEntityTypes {
+		validNames = append(validNames, entityType.DirName())
+		byName[entityType.DirName()] = entityType
+	}
+	for _, entity := range strings.Split(entities, ",") {
+		entityType, ok := byName[strings.TrimSpace(entity)]
+		if !ok {
+			return nil, fmt.Errorf("unknown entity %q, valid entities are %s", entity, strings.Join(validNames, ","))
+		}
+		entityTypes = append(entityTypes, entityType)
+	}
+	return entityTypes, nil
+}
+
+func plan(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	downloader := snapshotFlags(fs)
+	asJson := fs.Bool("json", false, "print the plan as json")
+	_ = fs.Parse(args)
+
+	p, err := downloader().Plan(ctx)
+	if err != nil {
+		return err
+	}
+	if *asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(p)
+	}
+	return p.Print(os.Stdout)
+}
+
+func sync(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	downloader := snapshotFlags(fs)
+	_ = fs.Parse(args)
+
+	result, err := downloader().SyncIncremental(ctx)
+	if err != nil {
+		return err
+	}
+	for _, partition := range result.Added {
+		fmt.Println("added", partition.EntityType, partition.UpdatedDate, len(partition.FilePaths))
+	}
+	for _, partition := range result.Removed {
+		fmt.Println("removed", partition.EntityType, partition.UpdatedDate, len(partition.FilePaths))
+	}
+	return nil
+}
+
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	downloader := snapshotFlags(fs)
+	asJson := fs.Bool("json", false, "print the report as json")
+	_ = fs.Parse(args)
+
+	report, err := downloader().VerifyRecordCounts()
+	if err != nil {
+		return err
+	}
+	if *asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	} else {
+		err = report.Print(os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+	return report.Err()
+}
+
+func diff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	oldDir := fs.String("old", "", "data directory of the old snapshot, e.g. /openalex-2024-01/data")
+	newDir := fs.String("new", "", "data directory of the new snapshot")
+	_ = fs.Parse(args)
+	if *oldDir == "" || *newDir == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	d, err := openalex.DiffSnapshotDirectories(*oldDir, *newDir)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	// cancel the running requests on ctrl+c, interrupted downloads are resumed with the next sync
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	var err error
+	switch os.Args[1] {
+	case "plan":
+		err = plan(ctx, os.Args[2:])
+	case "sync":
+		err = sync(ctx, os.Args[2:])
+	case "verify":
+		err = verify(os.Args[2:])
+	case "diff":
+		err = diff(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		slog.With("err", err).Error("command failed")
+		stop()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,523 @@
+package openalex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// DefaultApiBaseUrl is the base url of the OpenAlex REST API
+const DefaultApiBaseUrl = "https://api.openalex.org"
+
+// ErrNotFound is returned when the entity does not exist
+var ErrNotFound = errors.New("entity not found")
+
+// Client is a client for the OpenAlex REST API.
+// The fields must not be changed after the first request.
+type Client struct {
+	// HttpConfig contains the base url of the api, the http client, the timeout, the retries and the user agent
+	HttpConfig
+	Mailto    string         // email address for the polite pool
+	ApiKey    string         // optional api key for premium features
+	RateLimit float64        // maximal number of requests per second, 0 disables the rate limit
+	Workers   int            // number of concurrent requests of batched lookups
+	Cache     *ResponseCache // optional cache of the responses
+	initOnce  sync.Once
+	limiter   *rateLimiter
+}
+
+// NewClient creates a new client for the public REST API.
+// The mailto address is used for the polite pool and can be empty.
+func NewClient(mailto string) *Client {
+	config := DefaultHttpConfig()
+	config.BaseUrl = DefaultApiBaseUrl
+	return &Client{
+		HttpConfig: config,
+		Mailto:     mailto,
+		RateLimit:  10,
+		Workers:    4,
+	}
+}
+
+// Query contains the parameters of a list request
+type Query struct {
+	Filter  map[string]string // e.g. {"publication_year": "2020", "is_oa": "true"}
+	Search  string            // full text search
+	Sort    string            // e.g. "cited_by_count:desc"
+	Select  []string          // fields of the results, e.g. []string{"id", "doi"}
+	PerPage int               // results per page, at most 200
+}
+
+// values converts the query into url parameters
+func (q Query) values() url.Values {
+	values := url.Values{}
+	if len(q.Filter) > 0 {
+		keys := make([]string, 0, len(q.Filter))
+		for key := range q.Filter {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		filters := make([]string, 0, len(keys))
+		for _, key := range keys {
+			filters = append(filters, key+":"+q.Filter[key])
+		}
+		values.Set("filter", strings.Join(filters, ","))
+	}
+	if q.Search != "" {
+		values.Set("search", q.Search)
+	}
+	if q.Sort != "" {
+		values.Set("sort", q.Sort)
+	}
+	if len(q.Select) > 0 {
+		values.Set("select", strings.Join(q.Select, ","))
+	}
+	if q.PerPage > 0 {
+		values.Set("per-page", strconv.Itoa(q.PerPage))
+	}
+	return values
+}
+
+// ListMeta is the meta data of a list response
+type ListMeta struct {
+	Count      int     `json:"count"`
+	PerPage    int     `json:"per_page"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+// ListPage is a single page of a list response
+type ListPage struct {
+	Meta    ListMeta              `json:"meta"`
+	Results []jsoniter.RawMessage `json:"results"`
+}
+
+// init initializes the rate limiter
+func (c *Client) init() {
+	c.initOnce.Do(func() {
+		c.limiter = newRateLimiter(c.RateLimit)
+	})
+}
+
+// requestUrl builds the url of an api request
+func (c *Client) requestUrl(path string, values url.Values) string {
+	if c.Mailto != "" {
+		values.Set("mailto", c.Mailto)
+	}
+	if c.ApiKey != "" {
+		values.Set("api_key", c.ApiKey)
+	}
+	// the path is not escaped, as ids like doi:10.7717/peerj.4375 contain slashes
+	u := url.URL{Path: "/" + strings.TrimPrefix(path, "/")}
+	requestUrl := strings.TrimSuffix(c.BaseUrl, "/") + u.EscapedPath()
+	if len(values) > 0 {
+		requestUrl += "?" + values.Encode()
+	}
+	return requestUrl
+}
+
+// get sends a GET request with rate limiting and retries and returns the body
+func (c *Client) get(ctx context.Context, path string, values url.Values) (body []byte, err error) {
+	c.init()
+	logger := slog.With("path", path)
+	var key string
+	if c.Cache != nil {
+		key = cacheKey(c.BaseUrl, path, values)
+		cached, ok, errCache := c.Cache.Get(key)
+		if errCache != nil {
+			return nil, errCache
+		}
+		if ok {
+			return cached, nil
+		}
+		if c.Cache.CacheOnly {
+			return nil, fmt.Errorf("%w: %s", ErrCacheMiss, key)
+		}
+	}
+	requestUrl := c.requestUrl(path, values)
+
+	operation := func() error {
+		errWait := c.limiter.Wait(ctx)
+		if errWait != nil {
+			return backoff.Permanent(errWait)
+		}
+		reqCtx := ctx
+		if c.Timeout > 0 {
+			var cancel context.CancelFunc
+			reqCtx, cancel = context.WithTimeout(ctx, c.Timeout)
+			defer cancel()
+		}
+		req, errReq := c.newRequest(reqCtx, requestUrl)
+		if errReq != nil {
+			return backoff.Permanent(errReq)
+		}
+		if c.Mailto != "" {
+			req.Header.Set("User-Agent", req.Header.Get("User-Agent")+" (mailto:"+c.Mailto+")")
+		}
+		resp, errDo := c.client().Do(req)
+		if errDo != nil {
+			return errDo
+		}
+		defer resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+			body, err = io.ReadAll(resp.Body)
+			return err
+		case http.StatusNotFound:
+			return backoff.Permanent(ErrNotFound)
+		default:
+			return statusError(resp.StatusCode)
+		}
+	}
+	err = c.retry(ctx, logger, operation)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			logger.With("err", err).Error("Failed to fetch api response")
+		}
+		return nil, err
+	}
+	if c.Cache != nil {
+		err = c.Cache.Put(key, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// GetEntity fetches a single entity.
+// The id can be an OpenAlex ID (e.g. W2741809807 or https://openalex.org/W2741809807)
+// or an external id that is supported by the API, e.g. doi:10.7717/peerj.4375, orcid:..., ror:...
+func (c *Client) GetEntity(ctx context.Context, entityType FileEntityType, id string) (entity Entity, err error) {
+	entity, err = NewEntity(entityType)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.get(ctx, entityType.DirName()+"/"+strings.TrimPrefix(id, "https://openalex.org/"), url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, entity)
+	if err != nil {
+		return nil, err
+	}
+	if work, ok := entity.(*Work); ok {
+		work.GenerateAbstractFromInvertedIndex()
+	}
+	return entity, nil
+}
+
+// GetWork fetches a work by its OpenAlex ID or an external id, e.g. doi:10.7717/peerj.4375
+func (c *Client) GetWork(ctx context.Context, id string) (*Work, error) {
+	entity, err := c.GetEntity(ctx, WorksFileEntityType, id)
+	if err != nil {
+		return nil, err
+	}
+	return entity.(*Work), nil
+}
+
+// GetWorkByDOI fetches a work by its DOI, e.g. 10.7717/peerj.4375 or https://doi.org/10.7717/peerj.4375
+func (c *Client) GetWorkByDOI(ctx context.Context, doi string) (*Work, error) {
+	return c.GetWork(ctx, "doi:"+NormalizeDOI(doi))
+}
+
+// GetAuthor fetches an author by its OpenAlex ID or an external id, e.g. orcid:0000-0002-1298-3089
+func (c *Client) GetAuthor(ctx context.Context, id string) (*Author, error) {
+	entity, err := c.GetEntity(ctx, AuthorsFileEntityType, id)
+	if err != nil {
+		return nil, err
+	}
+	return entity.(*Author), nil
+}
+
+// GetAuthorByOrcid fetches an author by its ORCID, e.g. 0000-0002-1298-3089 or https://orcid.org/0000-0002-1298-3089
+func (c *Client) GetAuthorByOrcid(ctx context.Context, orcid string) (*Author, error) {
+	return c.GetAuthor(ctx, "orcid:"+strings.TrimPrefix(orcid, "https://orcid.org/"))
+}
+
+// GetInstitution fetches an institution by its OpenAlex ID or an external id, e.g. ror:02mhbdp94
+func (c *Client) GetInstitution(ctx context.Context, id string) (*Institution, error) {
+	entity, err := c.GetEntity(ctx, InstitutionsFileEntityType, id)
+	if err != nil {
+		return nil, err
+	}
+	return entity.(*Institution), nil
+}
+
+// GetInstitutionByRor fetches an institution by its ROR id, e.g. 02mhbdp94 or https://ror.org/02mhbdp94
+func (c *Client) GetInstitutionByRor(ctx context.Context, ror string) (*Institution, error) {
+	return c.GetInstitution(ctx, "ror:"+strings.TrimPrefix(ror, "https://ror.org/"))
+}
+
+// GetSource fetches a source by its OpenAlex ID or an external id, e.g. issn:2167-8359
+func (c *Client) GetSource(ctx context.Context, id string) (*Source, error) {
+	entity, err := c.GetEntity(ctx, SourcesFileEntityType, id)
+	if err != nil {
+		return nil, err
+	}
+	return entity.(*Source), nil
+}
+
+// GetConcept fetches a concept by its OpenAlex ID
+func (c *Client) GetConcept(ctx context.Context, id string) (*Concept, error) {
+	entity, err := c.GetEntity(ctx, ConceptsFileEntityType, id)
+	if err != nil {
+		return nil, err
+	}
+	return entity.(*Concept), nil
+}
+
+// GetPublisher fetches a publisher by its OpenAlex ID
+func (c *Client) GetPublisher(ctx context.Context, id string) (*Publisher, error) {
+	entity, err := c.GetEntity(ctx, PublishersFileEntityType, id)
+	if err != nil {
+		return nil, err
+	}
+	return entity.(*Publisher), nil
+}
+
+// GetFunder fetches a funder by its OpenAlex ID
+func (c *Client) GetFunder(ctx context.Context, id string) (*Funder, error) {
+	entity, err := c.GetEntity(ctx, FundersFileEntityType, id)
+	if err != nil {
+		return nil, err
+	}
+	return entity.(*Funder), nil
+}
+
+// GetTopic fetches a topic by its OpenAlex ID
+func (c *Client) GetTopic(ctx context.Context, id string) (*Topic, error) {
+	entity, err := c.GetEntity(ctx, TopicsFileEntityType, id)
+	if err != nil {
+		return nil, err
+	}
+	return entity.(*Topic), nil
+}
+
+// GetDomain fetches a domain by its OpenAlex ID
+func (c *Client) GetDomain(ctx context.Context, id string) (*Domain, error) {
+	entity, err := c.GetEntity(ctx, DomainsFileEntityType, id)
+	if err != nil {
+		return nil, err
+	}
+	return entity.(*Domain), nil
+}
+
+// ListPage fetches a single page of a list query.
+// Use "*" as cursor for the first page and ListMeta.NextCursor for the following pages.
+func (c *Client) ListPage(ctx context.Context, entityType FileEntityType, query Query, cursor string) (page *ListPage, err error) {
+	values := query.values()
+	if cursor != "" {
+		values.Set("cursor", cursor)
+	}
+	body, err := c.get(ctx, entityType.DirName(), values)
+	if err != nil {
+		return nil, err
+	}
+	page = &ListPage{}
+	err = json.Unmarshal(body, page)
+	if err != nil {
+		slog.With("err", err).With("entityType", entityType).Error("Failed to unmarshal page")
+		return nil, err
+	}
+	return page, nil
+}
+
+// Iterator iterates over all results of a list query with cursor pagination
+//
+//	it := client.Works(openalex.Query{Filter: map[string]string{"publication_year": "2020"}})
+//	for it.Next(ctx) {
+//		work := it.Value()
+//	}
+//	if it.Err() != nil { ... }
+type Iterator[T any] struct {
+	client     *Client
+	entityType FileEntityType
+	query      Query
+	cursor     string
+	results    []jsoniter.RawMessage
+	index      int
+	value      *T
+	meta       ListMeta
+	err        error
+}
+
+// NewIterator creates an iterator over the results of a list query that are decoded into T
+func NewIterator[T any](client *Client, entityType FileEntityType, query Query) *Iterator[T] {
+	return &Iterator[T]{
+		client:     client,
+		entityType: entityType,
+		query:      query,
+		cursor:     "*",
+	}
+}
+
+// Next advances to the next result and fetches the next page if needed.
+// It returns false when there are no more results or an error occurred.
+func (it *Iterator[T]) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	for it.index >= len(it.results) {
+		if it.cursor == "" {
+			return false
+		}
+		page, err := it.client.ListPage(ctx, it.entityType, it.query, it.cursor)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.meta = page.Meta
+		it.results = page.Results
+		it.index = 0
+		it.cursor = ""
+		if page.Meta.NextCursor != nil && len(page.Results) > 0 {
+			it.cursor = *page.Meta.NextCursor
+		}
+	}
+	var value T
+	it.err = json.Unmarshal(it.results[it.index], &value)
+	if it.err != nil {
+		return false
+	}
+	if work, ok := any(&value).(*Work); ok {
+		work.GenerateAbstractFromInvertedIndex()
+	}
+	it.value = &value
+	it.index++
+	return true
+}
+
+// Value returns the current result
+func (it *Iterator[T]) Value() *T {
+	return it.value
+}
+
+// Raw returns the json of the current result
+func (it *Iterator[T]) Raw() jsoniter.RawMessage {
+	if it.index == 0 {
+		return nil
+	}
+	return it.results[it.index-1]
+}
+
+// Count returns the total number of results reported by the API
+func (it *Iterator[T]) Count() int {
+	return it.meta.Count
+}
+
+// Err returns the error that stopped the iteration
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Works iterates over the works of a query
+func (c *Client) Works(query Query) *Iterator[Work] {
+	return NewIterator[Work](c, WorksFileEntityType, query)
+}
+
+// Authors iterates over the authors of a query
+func (c *Client) Authors(query Query) *Iterator[Author] {
+	return NewIterator[Author](c, AuthorsFileEntityType, query)
+}
+
+// Sources iterates over the sources of a query
+func (c *Client) Sources(query Query) *Iterator[Source] {
+	return NewIterator[Source](c, SourcesFileEntityType, query)
+}
+
+// Institutions iterates over the institutions of a query
+func (c *Client) Institutions(query Query) *Iterator[Institution] {
+	return NewIterator[Institution](c, InstitutionsFileEntityType, query)
+}
+
+// Concepts iterates over the concepts of a query
+func (c *Client) Concepts(query Query) *Iterator[Concept] {
+	return NewIterator[Concept](c, ConceptsFileEntityType, query)
+}
+
+// Publishers iterates over the publishers of a query
+func (c *Client) Publishers(query Query) *Iterator[Publisher] {
+	return NewIterator[Publisher](c, PublishersFileEntityType, query)
+}
+
+// Funders iterates over the funders of a query
+func (c *Client) Funders(query Query) *Iterator[Funder] {
+	return NewIterator[Funder](c, FundersFileEntityType, query)
+}
+
+// Topics iterates over the topics of a query
+func (c *Client) Topics(query Query) *Iterator[Topic] {
+	return NewIterator[Topic](c, TopicsFileEntityType, query)
+}
+
+// Domains iterates over the domains of a query
+func (c *Client) Domains(query Query) *Iterator[Domain] {
+	return NewIterator[Domain](c, DomainsFileEntityType, query)
+}
+
+// NormalizeDOI removes the resolver prefix and converts the DOI to lower case,
+// e.g. https://doi.org/10.7717/PeerJ.4375 becomes 10.7717/peerj.4375
+func NormalizeDOI(doi string) string {
+	doi = strings.TrimSpace(doi)
+	lower := strings.ToLower(doi)
+	for _, prefix := range []string{"https://doi.org/", "http://doi.org/", "https://dx.doi.org/", "http://dx.doi.org/", "doi.org/", "doi:"} {
+		if strings.HasPrefix(lower, prefix) {
+			lower = lower[len(prefix):]
+			break
+		}
+	}
+	return strings.TrimSpace(lower)
+}
+
+// rateLimiter spaces requests evenly to stay below a number of requests per second
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter creates a rate limiter, a non-positive rate disables it
+func newRateLimiter(requestsPerSecond float64) *rateLimiter {
+	if requestsPerSecond <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / requestsPerSecond)}
+}
+
+// Wait blocks until the next request is allowed
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	if l.interval == 0 {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
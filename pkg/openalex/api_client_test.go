@@ -0,0 +1,147 @@
+package openalex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientGetEntity(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if !strings.Contains(r.Header.Get("User-Agent"), "mailto:test@example.com") || r.URL.Query().Get("mailto") != "test@example.com" {
+			t.Error("missing polite pool parameters")
+		}
+		switch r.URL.Path {
+		case "/works/doi:10.7717/peerj.4375":
+			w.Write([]byte(`{"id":"https://openalex.org/W2741809807","doi":"https://doi.org/10.7717/peerj.4375","abstract_inverted_index":{"state":[1],"The":[0]}}`))
+		case "/authors/orcid:0000-0002-1298-3089":
+			w.Write([]byte(`{"id":"https://openalex.org/A5023888391"}`))
+		case "/institutions/ror:02mhbdp94":
+			w.Write([]byte(`{"id":"https://openalex.org/I27837315"}`))
+		case "/sources/S137773608":
+			w.Write([]byte(`{"id":"https://openalex.org/S137773608"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := NewClient("test@example.com")
+	client.BaseUrl = server.URL
+	ctx := context.Background()
+
+	work, err := client.GetWorkByDOI(ctx, "https://doi.org/10.7717/PeerJ.4375")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if work.GetID() != "https://openalex.org/W2741809807" || work.Abstract != "The state" {
+		t.Error("unexpected work", work.ID, work.Abstract)
+	}
+	author, err := client.GetAuthorByOrcid(ctx, "https://orcid.org/0000-0002-1298-3089")
+	if err != nil || author.ID != "https://openalex.org/A5023888391" {
+		t.Error("unexpected author", author, err)
+	}
+	institution, err := client.GetInstitutionByRor(ctx, "https://ror.org/02mhbdp94")
+	if err != nil || institution.ID != "https://openalex.org/I27837315" {
+		t.Error("unexpected institution", institution, err)
+	}
+	source, err := client.GetSource(ctx, "https://openalex.org/S137773608")
+	if err != nil || source.ID != "https://openalex.org/S137773608" {
+		t.Error("unexpected source", source, err)
+	}
+	_, err = client.GetWork(ctx, "W1")
+	if !errors.Is(err, ErrNotFound) {
+		t.Error("expected not found", err)
+	}
+}
+
+func TestClientIterator(t *testing.T) {
+	server, requests := newTestApi(t, map[string][]string{
+		"works": {`{"id":"W1"}`, `{"id":"W2"}`, `{"id":"W3"}`, `{"id":"W4"}`, `{"id":"W5"}`},
+	})
+	client := NewClient("")
+	client.BaseUrl = server.URL
+	client.RateLimit = 0
+
+	it := client.Works(Query{
+		Filter:  map[string]string{"publication_year": "2020", "is_oa": "true"},
+		Search:  "open access",
+		Sort:    "cited_by_count:desc",
+		Select:  []string{"id", "doi"},
+		PerPage: 2,
+	})
+	var ids []string
+	for it.Next(context.Background()) {
+		ids = append(ids, it.Value().ID)
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if strings.Join(ids, ",") != "W1,W2,W3,W4,W5" || it.Count() != 5 {
+		t.Error("unexpected results", ids, it.Count())
+	}
+	urls := requests()
+	if len(urls) != 3 {
+		t.Fatal("expected 3 pages", urls)
+	}
+	for _, param := range []string{"filter=is_oa%3Atrue%2Cpublication_year%3A2020", "search=open+access", "sort=cited_by_count%3Adesc", "select=id%2Cdoi", "cursor=%2A"} {
+		if !strings.Contains(urls[0], param) {
+			t.Error("missing parameter", param, urls[0])
+		}
+	}
+}
+
+func TestClientRetryAndRateLimit(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"id":"https://openalex.org/W1"}`))
+	}))
+	defer server.Close()
+	client := NewClient("")
+	client.BaseUrl = server.URL
+	client.RateLimit = 20
+
+	start := time.Now()
+	_, err := client.GetWork(context.Background(), "W1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 3 {
+		t.Error("expected 2 retries", calls.Load())
+	}
+	for i := 0; i < 4; i++ {
+		_, err = client.GetWork(context.Background(), "W1")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 7 requests with 20 requests per second take at least 300ms
+	if time.Since(start) < 300*time.Millisecond {
+		t.Error("rate limit was not applied", time.Since(start))
+	}
+
+	client.MaxRetries = 0
+	calls.Store(0)
+	_, err = client.GetWork(context.Background(), "W1")
+	if !errors.Is(err, ErrStatusNotOK) {
+		t.Error("expected status error without retries", err)
+	}
+}
+
+func TestNormalizeDOI(t *testing.T) {
+	for _, doi := range []string{"10.7717/peerj.4375", "https://doi.org/10.7717/PEERJ.4375", "doi:10.7717/peerj.4375", " http://dx.doi.org/10.7717/peerj.4375 "} {
+		if NormalizeDOI(doi) != "10.7717/peerj.4375" {
+			t.Error("unexpected doi", doi, NormalizeDOI(doi))
+		}
+	}
+}
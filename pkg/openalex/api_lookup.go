@@ -0,0 +1,167 @@
+package openalex
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// MaxLookupBatchSize is the maximal number of identifiers in a single OR filter
+const MaxLookupBatchSize = 50
+
+// WorkLookupResult contains the result of a batched lookup of works
+type WorkLookupResult struct {
+	Found    map[string]*Work // works by input identifier
+	NotFound []string         // input identifiers without a work, in the order of the input
+	Failed   []string         // input identifiers of the batches that failed, in the order of the input
+}
+
+// GetWorksByDOIs resolves DOIs to works.
+// The DOIs are normalized, chunked into OR filters of up to MaxLookupBatchSize DOIs
+// and fetched concurrently with Client.Workers requests within the rate limit.
+// The keys of WorkLookupResult.Found are the DOIs as they were passed in.
+// The DOIs of failed batches are listed in WorkLookupResult.Failed and not in NotFound,
+// the result is returned with the error.
+func (c *Client) GetWorksByDOIs(ctx context.Context, dois []string) (*WorkLookupResult, error) {
+	return c.lookupWorks(ctx, "doi", dois, NormalizeDOI, func(work *Work) string {
+		return NormalizeDOI(work.Doi)
+	})
+}
+
+// GetWorksByIDs resolves OpenAlex IDs (e.g. W2741809807 or https://openalex.org/W2741809807) to works.
+// See GetWorksByDOIs for the batching.
+func (c *Client) GetWorksByIDs(ctx context.Context, ids []string) (*WorkLookupResult, error) {
+	return c.lookupWorks(ctx, "openalex", ids, normalizeOpenAlexID, func(work *Work) string {
+		return normalizeOpenAlexID(work.GetID())
+	})
+}
+
+// normalizeOpenAlexID removes the https://openalex.org/ prefix and converts the id to upper case
+func normalizeOpenAlexID(id string) string {
+	id = strings.TrimSpace(id)
+	lower := strings.ToLower(id)
+	for _, prefix := range []string{"https://openalex.org/", "http://openalex.org/", "openalex.org/"} {
+		if strings.HasPrefix(lower, prefix) {
+			id = id[len(prefix):]
+			break
+		}
+	}
+	return strings.ToUpper(id)
+}
+
+// lookupWorks fetches the works of the normalized identifiers with OR filters on the filter key
+// and maps them back to the input identifiers
+func (c *Client) lookupWorks(ctx context.Context, filterKey string, inputs []string, normalize func(string) string, workKey func(*Work) string) (result *WorkLookupResult, err error) {
+	logger := slog.With("filterKey", filterKey).With("identifiers", len(inputs))
+	result = &WorkLookupResult{Found: make(map[string]*Work, len(inputs))}
+
+	// deduplicate the normalized identifiers
+	inputsByKey := make(map[string][]string, len(inputs))
+	var keys []string
+	for _, input := range inputs {
+		key := normalize(input)
+		if key == "" {
+			continue
+		}
+		if _, ok := inputsByKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		inputsByKey[key] = append(inputsByKey[key], input)
+	}
+
+	// identifiers with the separators of the filter syntax can not be part of an OR filter
+	var batches [][]string
+	var batch []string
+	for _, key := range keys {
+		if strings.ContainsAny(key, ",|") {
+			batches = append(batches, []string{key})
+			continue
+		}
+		batch = append(batch, key)
+		if len(batch) == MaxLookupBatchSize {
+			batches = append(batches, batch)
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	workers := c.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var mu sync.Mutex
+	var errs []error
+	found := make(map[string]*Work, len(keys))
+	failed := make(map[string]struct{})
+	queue := make(chan []string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range queue {
+				works, errBatch := c.lookupBatch(ctx, filterKey, batch)
+				mu.Lock()
+				if errBatch != nil {
+					errs = append(errs, errBatch)
+					for _, key := range batch {
+						failed[key] = struct{}{}
+					}
+				}
+				for _, work := range works {
+					found[workKey(work)] = work
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, batch := range batches {
+		queue <- batch
+	}
+	close(queue)
+	wg.Wait()
+
+	for _, input := range inputs {
+		key := normalize(input)
+		if work, ok := found[key]; ok {
+			result.Found[input] = work
+		} else if _, ok = failed[key]; ok {
+			result.Failed = append(result.Failed, input)
+		} else {
+			result.NotFound = append(result.NotFound, input)
+		}
+	}
+	err = errors.Join(errs...)
+	if err != nil {
+		logger.With("err", err).With("failed", len(result.Failed)).Error("error while looking up works")
+		return result, err
+	}
+	logger.With("found", len(result.Found)).With("notFound", len(result.NotFound)).Info("Finished looking up works")
+	return result, nil
+}
+
+// lookupBatch fetches the works of a single OR filter
+func (c *Client) lookupBatch(ctx context.Context, filterKey string, keys []string) (works []*Work, err error) {
+	if len(keys) == 1 && strings.ContainsAny(keys[0], ",|") {
+		work, errGet := c.GetWork(ctx, filterKey+":"+keys[0])
+		if errors.Is(errGet, ErrNotFound) {
+			return nil, nil
+		}
+		if errGet != nil {
+			return nil, errGet
+		}
+		return []*Work{work}, nil
+	}
+	it := c.Works(Query{
+		Filter:  map[string]string{filterKey: strings.Join(keys, "|")},
+		PerPage: MaxLookupBatchSize,
+	})
+	for it.Next(ctx) {
+		works = append(works, it.Value())
+	}
+	return works, it.Err()
+}
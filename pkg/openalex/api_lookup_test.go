@@ -0,0 +1,106 @@
+package openalex
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClientGetWorksByDOIs(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		filter := r.URL.Query().Get("filter")
+		if !strings.HasPrefix(filter, "doi:") {
+			t.Error("unexpected filter", filter)
+		}
+		dois := strings.Split(strings.TrimPrefix(filter, "doi:"), "|")
+		if len(dois) > MaxLookupBatchSize {
+			t.Error("too many dois in a batch", len(dois))
+		}
+		var results []string
+		for _, doi := range dois {
+			// every DOI with an even suffix exists
+			var n int
+			fmt.Sscanf(doi, "10.1234/%d", &n)
+			if n%2 == 0 {
+				results = append(results, fmt.Sprintf(`{"id":"https://openalex.org/W%d","doi":"https://doi.org/%s"}`, n, strings.ToUpper(doi)))
+			}
+		}
+		fmt.Fprintf(w, `{"meta":{"count":%d,"per_page":50,"next_cursor":null},"results":[%s]}`, len(results), strings.Join(results, ","))
+	}))
+	defer server.Close()
+	client := NewClient("")
+	client.BaseUrl = server.URL
+	client.RateLimit = 0
+
+	var dois []string
+	for i := 0; i < 120; i++ {
+		dois = append(dois, fmt.Sprintf("10.1234/%d", i))
+	}
+	dois = append(dois, "https://doi.org/10.1234/2", "")
+	result, err := client.GetWorksByDOIs(context.Background(), dois)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 3 {
+		t.Error("expected 3 batches", requests.Load())
+	}
+	if len(result.Found) != 61 || len(result.NotFound) != 61 {
+		t.Error("unexpected result", len(result.Found), len(result.NotFound))
+	}
+	if result.Found["https://doi.org/10.1234/2"].ID != "https://openalex.org/W2" || result.Found["10.1234/2"].ID != "https://openalex.org/W2" {
+		t.Error("unexpected work", result.Found["https://doi.org/10.1234/2"])
+	}
+	if result.NotFound[0] != "10.1234/1" || result.NotFound[60] != "" {
+		t.Error("unexpected not found", result.NotFound)
+	}
+}
+
+func TestClientGetWorksByDOIsFailedBatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dois := strings.Split(strings.TrimPrefix(r.URL.Query().Get("filter"), "doi:"), "|")
+		// the batch of the first DOI fails
+		if dois[0] == "10.1234/0" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var results []string
+		for _, doi := range dois {
+			results = append(results, fmt.Sprintf(`{"id":"https://openalex.org/W1","doi":"https://doi.org/%s"}`, doi))
+		}
+		fmt.Fprintf(w, `{"meta":{"count":%d,"per_page":50,"next_cursor":null},"results":[%s]}`, len(results), strings.Join(results, ","))
+	}))
+	defer server.Close()
+	client := NewClient("")
+	client.BaseUrl = server.URL
+	client.RateLimit = 0
+	client.MaxRetries = 0
+
+	var dois []string
+	for i := 0; i < 60; i++ {
+		dois = append(dois, fmt.Sprintf("10.1234/%d", i))
+	}
+	result, err := client.GetWorksByDOIs(context.Background(), dois)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(result.Failed) != MaxLookupBatchSize || len(result.Found) != 10 || len(result.NotFound) != 0 {
+		t.Error("unexpected result", len(result.Failed), len(result.Found), len(result.NotFound))
+	}
+	if result.Failed[0] != "10.1234/0" {
+		t.Error("unexpected failed", result.Failed)
+	}
+}
+
+func TestNormalizeOpenAlexID(t *testing.T) {
+	for _, id := range []string{"W2741809807", "w2741809807", "https://openalex.org/W2741809807", " https://openalex.org/w2741809807"} {
+		if normalizeOpenAlexID(id) != "W2741809807" {
+			t.Error("unexpected id", id, normalizeOpenAlexID(id))
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package openalex
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// ErrTooManyLineErrors is returned when a file has more line errors than allowed by the processor
+var ErrTooManyLineErrors = errors.New("too many line errors")
+
+// ErrorPolicy defines how the processor handles lines that can not be handled
+type ErrorPolicy int
+
+const (
+	ErrorFailFast      ErrorPolicy = iota // return the error of the line and stop the file
+	ErrorSkipAndRecord                    // skip the line and write it to the dead letters
+	ErrorMaxPerFile                       // like ErrorSkipAndRecord, but fail the file after Processor.MaxErrorsPerFile errors
+)
+
+// DeadLetter is a line that could not be handled
+type DeadLetter struct {
+	FilePath string `json:"file_path"`
+	Line     int    `json:"line"` // line number in the file, starting with 1
+	Error    string `json:"error"`
+	Raw      string `json:"raw"`
+}
+
+// DeadLetterWriter appends dead letters as json lines to a file.
+// Every line of a file is written only once, so a file that is processed again does not duplicate its dead letters.
+// It is safe for concurrent use.
+type DeadLetterWriter struct {
+	FilePath string
+	mu       sync.Mutex
+	file     *os.File
+	count    int
+	written  map[deadLetterKey]struct{}
+}
+
+// deadLetterKey identifies the line of a dead letter
+type deadLetterKey struct {
+	filePath string
+	line     int
+}
+
+// NewDeadLetterWriter opens the dead letter file, existing dead letters are kept and not written again
+func NewDeadLetterWriter(filePath string) (w *DeadLetterWriter, err error) {
+	logger := slog.With("filePath", filePath)
+	written, err := readDeadLetterKeys(filePath)
+	if err != nil {
+		logger.With("err", err).Error("error reading dead letter file")
+		return nil, err
+	}
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.With("err", err).Error("error opening dead letter file")
+		return nil, err
+	}
+	return &DeadLetterWriter{FilePath: filePath, file: file, written: written}, nil
+}
+
+// readDeadLetterKeys reads the keys of the dead letters of an existing file
+func readDeadLetterKeys(filePath string) (written map[deadLetterKey]struct{}, err error) {
+	written = make(map[deadLetterKey]struct{})
+	file, err := os.Open(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return written, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 1024*1024), maxLineCapacity)
+	for scanner.Scan() {
+		var deadLetter DeadLetter
+		if errDecode := json.Unmarshal(scanner.Bytes(), &deadLetter); errDecode != nil {
+			// an incomplete last line of a stopped process
+			continue
+		}
+		written[deadLetterKey{filePath: deadLetter.FilePath, line: deadLetter.Line}] = struct{}{}
+	}
+	return written, scanner.Err()
+}
+
+// Write appends a dead letter, the line is written at once so that the file stays valid if the process stops.
+// A dead letter of a line that was already written is skipped.
+func (w *DeadLetterWriter) Write(deadLetter DeadLetter) error {
+	data, err := json.Marshal(deadLetter)
+	if err != nil {
+		return err
+	}
+	key := deadLetterKey{filePath: deadLetter.FilePath, line: deadLetter.Line}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.written[key]; ok {
+		return nil
+	}
+	_, err = w.file.Write(append(data, '\n'))
+	if err != nil {
+		slog.With("err", err).With("filePath", w.FilePath).Error("error writing dead letter")
+		return err
+	}
+	w.written[key] = struct{}{}
+	w.count++
+	return nil
+}
+
+// Count returns the number of dead letters written by this writer
+func (w *DeadLetterWriter) Count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+// Close closes the dead letter file
+func (w *DeadLetterWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// handleLineError applies the error policy to the error of a line.
+// It returns nil if the line is skipped, fileErrors is the number of errors in the file including this one.
+func (p *Processor) handleLineError(ctx context.Context, filePath string, lineNumber int, line string, lineErr error, fileErrors int) error {
+	if p.ErrorPolicy == ErrorFailFast || ctx.Err() != nil {
+		return lineErr
+	}
+	if p.ErrorPolicy == ErrorMaxPerFile && fileErrors > p.MaxErrorsPerFile {
+		return fmt.Errorf("%w: %d errors, the last one at line %d: %w", ErrTooManyLineErrors, fileErrors, lineNumber, lineErr)
+	}
+	slog.
+		With("err", lineErr).
+		With("filePath", filePath).
+		With("line", lineNumber).
+		Warn("Skipping line")
+	if p.DeadLetters == nil {
+		return nil
+	}
+	return p.DeadLetters.Write(DeadLetter{
+		FilePath: filePath,
+		Line:     lineNumber,
+		Error:    lineErr.Error(),
+		Raw:      line,
+	})
+}
+
+// ReplayDeadLetters passes the raw lines of a dead letter file to the LineHandler with their original file paths,
+// e.g. after the handler was fixed. The lines are rewritten by the IDRewriter like in ParseFile.
+// The dead letters that fail again are returned with their new error,
+// so that they can be written to a new dead letter file.
+func (p *Processor) ReplayDeadLetters(ctx context.Context, filePath string) (replayed int, failed []DeadLetter, err error) {
+	logger := slog.With("filePath", filePath)
+	file, err := os.Open(filePath)
+	if err != nil {
+		logger.With("err", err).Error("error opening dead letter file")
+		return 0, nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 1024*1024), maxLineCapacity)
+	for scanner.Scan() {
+		if err = ctx.Err(); err != nil {
+			return replayed, failed, err
+		}
+		var deadLetter DeadLetter
+		err = json.Unmarshal(scanner.Bytes(), &deadLetter)
+		if err != nil {
+			logger.With("err", err).Error("error decoding dead letter")
+			return replayed, failed, err
+		}
+		entityType, errLine := GetEntityType(deadLetter.FilePath)
+		if errLine == nil || p.IDRewriter == nil {
+			errLine = p.handleLine(entityType, deadLetter.FilePath, deadLetter.Raw)
+		}
+		if errLine != nil {
+			deadLetter.Error = errLine.Error()
+			failed = append(failed, deadLetter)
+			continue
+		}
+		replayed++
+	}
+	err = scanner.Err()
+	if err != nil {
+		logger.With("err", err).Error("error scanning dead letter file")
+		return replayed, failed, err
+	}
+	logger.With("replayed", replayed).With("failed", len(failed)).Info("Finished replaying dead letters")
+	return replayed, failed, nil
+}
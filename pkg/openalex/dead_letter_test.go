@@ -0,0 +1,177 @@
+package openalex
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessorErrorPolicy(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "data", "works", "updated_date=2023-05-01", "part_000.gz")
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, gzipBytes(t, `{"id":"W1"}`, `bad 1`, `{"id":"W2"}`, `bad 2`, `{"id":"W3"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	errBad := errors.New("bad line")
+	fixed := false
+	var handled []string
+	handler := func(filePath string, line string) error {
+		if strings.HasPrefix(line, "bad") && !fixed {
+			return errBad
+		}
+		handled = append(handled, line)
+		return nil
+	}
+	p := Processor{DirectoryPath: filepath.Join(dir, "data"), LineHandler: handler}
+
+	// fail fast by default
+	if _, err := p.ParseFile(filePath); !errors.Is(err, errBad) || len(handled) != 1 {
+		t.Error("expected the first error", err, handled)
+	}
+
+	// skip and record
+	deadLetterPath := filepath.Join(dir, "dead_letters.jsonl")
+	deadLetters, err := NewDeadLetterWriter(deadLetterPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.ErrorPolicy = ErrorSkipAndRecord
+	p.DeadLetters = deadLetters
+	handled = nil
+	count, err := p.ParseFile(filePath)
+	if err != nil || count != 3 || len(handled) != 3 || deadLetters.Count() != 2 {
+		t.Error("expected skipped lines", count, err, handled, deadLetters.Count())
+	}
+	if err = deadLetters.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// fail after too many errors
+	p.ErrorPolicy = ErrorMaxPerFile
+	p.MaxErrorsPerFile = 1
+	p.DeadLetters = nil
+	if _, err = p.ParseFile(filePath); !errors.Is(err, ErrTooManyLineErrors) || !errors.Is(err, errBad) {
+		t.Error("expected too many errors", err)
+	}
+	p.MaxErrorsPerFile = 2
+	if _, err = p.ParseFile(filePath); err != nil {
+		t.Error("expected no error", err)
+	}
+
+	// replay after the fix
+	replayed, failed, err := p.ReplayDeadLetters(context.Background(), deadLetterPath)
+	if err != nil || replayed != 0 || len(failed) != 2 || failed[0].Line != 2 || failed[1].Raw != "bad 2" || failed[0].FilePath != filePath {
+		t.Error("expected failed dead letters", replayed, failed, err)
+	}
+	fixed = true
+	handled = nil
+	replayed, failed, err = p.ReplayDeadLetters(context.Background(), deadLetterPath)
+	if err != nil || replayed != 2 || len(failed) != 0 || handled[0] != "bad 1" {
+		t.Error("expected replayed dead letters", replayed, failed, err, handled)
+	}
+}
+
+func TestProcessorReplayDeadLettersIDRewriter(t *testing.T) {
+	dir := t.TempDir()
+	deadLetterPath := filepath.Join(dir, "dead_letters.jsonl")
+	deadLetters, err := NewDeadLetterWriter(deadLetterPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deadLetters.Write(DeadLetter{
+		FilePath: filepath.Join(dir, "works", "updated_date=2023-05-01", "part_000.gz"),
+		Line:     1,
+		Error:    "bad line",
+		Raw:      `{"id":"W5","referenced_works":["W1"]}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = deadLetters.Close(); err != nil {
+		t.Fatal(err)
+	}
+	resolver := NewMergedIDResolver()
+	resolver.Add(MergedID{MergeDate: testMergeDate(t, "2023-01-01"), ID: "W1", MergeIntoID: "W2"})
+	var lines []string
+	p := Processor{
+		IDRewriter: NewIDRewriter(resolver),
+		LineHandler: func(filePath string, line string) error {
+			lines = append(lines, line)
+			return nil
+		},
+	}
+	replayed, failed, err := p.ReplayDeadLetters(context.Background(), deadLetterPath)
+	if err != nil || replayed != 1 || len(failed) != 0 {
+		t.Fatal("expected a replayed dead letter", replayed, failed, err)
+	}
+	if len(lines) != 1 || !strings.Contains(lines[0], `"referenced_works":["W2"]`) {
+		t.Error("expected the rewritten line", lines)
+	}
+}
+
+func TestDeadLetterWriterRerun(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "works", "updated_date=2023-05-01", "part_000.gz")
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, gzipBytes(t, `{"id":"W1"}`, `bad 1`, `{"id":"W2"}`, `bad 2`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadLetterPath := filepath.Join(dir, "dead_letters.jsonl")
+	deadLetters, err := NewDeadLetterWriter(deadLetterPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Processor{
+		DirectoryPath:    dir,
+		ErrorPolicy:      ErrorMaxPerFile,
+		MaxErrorsPerFile: 1,
+		DeadLetters:      deadLetters,
+		LineHandler: func(filePath string, line string) error {
+			if strings.HasPrefix(line, "bad") {
+				return errors.New("bad line")
+			}
+			return nil
+		},
+	}
+
+	// the file fails at the second bad line and is processed again
+	for i := 0; i < 2; i++ {
+		if _, err = p.ParseFile(filePath); !errors.Is(err, ErrTooManyLineErrors) {
+			t.Fatal("expected too many errors", err)
+		}
+	}
+	if deadLetters.Count() != 1 {
+		t.Error("expected a single dead letter", deadLetters.Count())
+	}
+	if err = deadLetters.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the dead letters of a previous process are not written again
+	deadLetters, err = NewDeadLetterWriter(deadLetterPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.DeadLetters = deadLetters
+	if _, err = p.ParseFile(filePath); !errors.Is(err, ErrTooManyLineErrors) {
+		t.Fatal("expected too many errors", err)
+	}
+	if err = deadLetters.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if deadLetters.Count() != 0 {
+		t.Error("expected no new dead letter", deadLetters.Count())
+	}
+	replayed, failed, err := p.ReplayDeadLetters(context.Background(), deadLetterPath)
+	if err != nil || replayed != 0 || len(failed) != 1 {
+		t.Error("expected the dead letter once", replayed, failed, err)
+	}
+}
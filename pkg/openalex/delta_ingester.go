@@ -0,0 +1,127 @@
+package openalex
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// ErrNoHandler is returned when neither a line handler nor an entity handler is set
+var ErrNoHandler = errors.New("no handler set")
+
+// ApiEntityTypes are the entity types that can be fetched from the REST API
+var ApiEntityTypes = []FileEntityType{
+	WorksFileEntityType,
+	AuthorsFileEntityType,
+	SourcesFileEntityType,
+	InstitutionsFileEntityType,
+	ConceptsFileEntityType,
+	PublishersFileEntityType,
+	FundersFileEntityType,
+	TopicsFileEntityType,
+	DomainsFileEntityType,
+}
+
+// DeltaIngester fetches the entities that were updated since a date from the REST API.
+// It is used to ingest the changes between two monthly snapshots.
+// The entities are passed to the same handlers that are used by the Processor.
+type DeltaIngester struct {
+	Client          *Client          // the from_updated_date filter requires a client with an api key
+	FromUpdatedDate string           // e.g. 2024-01-01
+	EntityTypes     []FileEntityType // entity types to fetch, ApiEntityTypes if empty
+	PerPage         int              // results per page, at most 200
+	// LineHandler receives every result as a json line, the result is not decoded for it.
+	// The file path is ApiFilePath(entityType), so GetEntityType works as for the snapshot files.
+	LineHandler LineHandler
+	// EntityHandler receives every result decoded into the struct of its entity type
+	EntityHandler EntityHandler
+}
+
+// NewDeltaIngester creates a new delta ingester that uses the client
+func NewDeltaIngester(client *Client, fromUpdatedDate string, lineHandler LineHandler) *DeltaIngester {
+	return &DeltaIngester{
+		Client:          client,
+		FromUpdatedDate: fromUpdatedDate,
+		PerPage:         200,
+		LineHandler:     lineHandler,
+	}
+}
+
+// ApiFilePath returns the file path that is passed to the LineHandler for entities of the REST API
+func ApiFilePath(entityType FileEntityType) string {
+	return path.Join("api", entityType.DirName())
+}
+
+// Run fetches all entity types and returns the number of handled entities per entity type
+func (di *DeltaIngester) Run(ctx context.Context) (counts map[FileEntityType]int, err error) {
+	logger := slog.With("fromUpdatedDate", di.FromUpdatedDate)
+	if di.LineHandler == nil && di.EntityHandler == nil {
+		return nil, ErrNoHandler
+	}
+	entityTypes := di.EntityTypes
+	if len(entityTypes) == 0 {
+		entityTypes = ApiEntityTypes
+	}
+	counts = make(map[FileEntityType]int, len(entityTypes))
+	for _, entityType := range entityTypes {
+		logger.With("entityType", entityType).Info("Start ingesting entities")
+		count, errIngest := di.ingest(ctx, entityType)
+		counts[entityType] = count
+		if errIngest != nil {
+			logger.With("err", errIngest).With("entityType", entityType).Error("error while ingesting entities")
+			return counts, errIngest
+		}
+		logger.With("entityType", entityType).With("count", count).Info("Finished ingesting entities")
+	}
+	return counts, nil
+}
+
+// ingest pages through the updated entities of an entity type with cursor pagination
+func (di *DeltaIngester) ingest(ctx context.Context, entityType FileEntityType) (count int, err error) {
+	filePath := ApiFilePath(entityType)
+	it := NewIterator[jsoniter.RawMessage](di.Client, entityType, Query{
+		Filter:  map[string]string{"from_updated_date": di.FromUpdatedDate},
+		PerPage: di.PerPage,
+	})
+	for it.Next(ctx) {
+		err = di.handle(entityType, filePath, *it.Value())
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, it.Err()
+}
+
+// handle passes a result to the handlers, it is only decoded if there is an EntityHandler
+func (di *DeltaIngester) handle(entityType FileEntityType, filePath string, result jsoniter.RawMessage) (err error) {
+	if di.LineHandler != nil {
+		err = di.LineHandler(filePath, string(result))
+		if err != nil {
+			return err
+		}
+	}
+	if di.EntityHandler == nil {
+		return nil
+	}
+	entity, err := NewEntity(entityType)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(result, entity)
+	if err != nil {
+		slog.With("err", err).With("entityType", entityType).Error("error unmarshalling result")
+		return err
+	}
+	if work, ok := entity.(*Work); ok {
+		work.GenerateAbstractFromInvertedIndex()
+	}
+	err = di.EntityHandler(entityType, entity)
+	if err != nil {
+		return err
+	}
+	return nil
+}
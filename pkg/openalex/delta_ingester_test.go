@@ -0,0 +1,123 @@
+package openalex
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestApi mimics the list endpoints of the REST API with cursor pagination.
+// The results are the json objects of each endpoint, e.g. "works".
+func newTestApi(t *testing.T, results map[string][]string) (server *httptest.Server, requests func() []string) {
+	var mu sync.Mutex
+	var urls []string
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		urls = append(urls, r.URL.String())
+		mu.Unlock()
+		endpoint := strings.TrimPrefix(r.URL.Path, "/")
+		entities, ok := results[endpoint]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		perPage, err := strconv.Atoi(r.URL.Query().Get("per-page"))
+		if err != nil || perPage <= 0 {
+			perPage = 25
+		}
+		start := 0
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" && cursor != "*" {
+			start, _ = strconv.Atoi(cursor)
+		}
+		end := min(start+perPage, len(entities))
+		nextCursor := "null"
+		if end < len(entities) {
+			nextCursor = strconv.Quote(strconv.Itoa(end))
+		}
+		fmt.Fprintf(w, `{"meta":{"count":%d,"db_response_time_ms":1,"page":null,"per_page":%d,"next_cursor":%s},"results":[%s],"group_by":[]}`,
+			len(entities), perPage, nextCursor, strings.Join(entities[start:end], ","))
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, urls...)
+	}
+}
+
+func TestDeltaIngester(t *testing.T) {
+	server, requests := newTestApi(t, map[string][]string{
+		"works": {
+			`{"id":"https://openalex.org/W1","abstract_inverted_index":{"Hello":[0],"world":[1]}}`,
+			`{"id":"https://openalex.org/W2"}`,
+			`{"id":"https://openalex.org/W3"}`,
+		},
+		"institutions": {`{"id":"https://openalex.org/I1"}`},
+	})
+
+	var lines []string
+	var abstracts []string
+	client := NewClient("test@example.com")
+	client.BaseUrl = server.URL
+	di := NewDeltaIngester(client, "2024-01-01", func(filePath string, line string) error {
+		entityType, err := GetEntityType(filePath)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, string(entityType)+" "+line)
+		return nil
+	})
+	di.PerPage = 2
+	di.EntityTypes = []FileEntityType{WorksFileEntityType, InstitutionsFileEntityType}
+	di.EntityHandler = func(fileEntityType FileEntityType, entity Entity) error {
+		if work, ok := entity.(*Work); ok && work.Abstract != "" {
+			abstracts = append(abstracts, work.Abstract)
+		}
+		return nil
+	}
+
+	counts, err := di.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[WorksFileEntityType] != 3 || counts[InstitutionsFileEntityType] != 1 {
+		t.Error("unexpected counts", counts)
+	}
+	if len(lines) != 4 || !strings.HasPrefix(lines[3], string(InstitutionsFileEntityType)) {
+		t.Error("unexpected lines", lines)
+	}
+	if len(abstracts) != 1 || abstracts[0] != "Hello world" {
+		t.Error("unexpected abstracts", abstracts)
+	}
+	urls := requests()
+	if len(urls) != 3 {
+		t.Fatal("expected 2 pages of works and 1 page of institutions", urls)
+	}
+	if !strings.Contains(urls[0], "filter=from_updated_date%3A2024-01-01") || !strings.Contains(urls[0], "mailto=") {
+		t.Error("missing query parameters", urls[0])
+	}
+}
+
+func TestDeltaIngesterHandleWithoutEntityHandler(t *testing.T) {
+	// the id of a work is a string, so the result can not be decoded into a Work
+	result := []byte(`{"id":5}`)
+	var lines []string
+	di := &DeltaIngester{LineHandler: func(filePath string, line string) error {
+		lines = append(lines, line)
+		return nil
+	}}
+	if err := di.handle(WorksFileEntityType, ApiFilePath(WorksFileEntityType), result); err != nil || len(lines) != 1 {
+		t.Error("expected the raw line without decoding", err, lines)
+	}
+	di.EntityHandler = func(fileEntityType FileEntityType, entity Entity) error {
+		return nil
+	}
+	if err := di.handle(WorksFileEntityType, ApiFilePath(WorksFileEntityType), result); err == nil {
+		t.Error("expected a decoding error")
+	}
+}
@@ -1,39 +1,596 @@
 package openalex
 
 import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
 )
 
+// DefaultSnapshotBaseUrl is the https endpoint of the public openalex bucket
+const DefaultSnapshotBaseUrl = "https://openalex.s3.amazonaws.com"
+
+// ErrIncompleteDownload is returned when the size of a downloaded file does not match the manifest
+var ErrIncompleteDownload = errors.New("size of the downloaded file does not match")
+
+// snapshotS3Prefix is the prefix of the urls that are listed in the manifests
+const snapshotS3Prefix = "s3://openalex/"
+
+// mergedIdsPrefix is the key prefix of the merged ids files in the bucket
+const mergedIdsPrefix = "data/merged_ids/"
+
+// SnapshotDownloader mirrors the openalex snapshot bucket into a local directory.
+// The layout of the destination matches the bucket, e.g. <DestPath>/data/works/updated_date=2023-05-16/part_000.gz
+type SnapshotDownloader struct {
+	// HttpConfig contains the base url of the bucket (DefaultSnapshotBaseUrl or a mirror),
+	// the http client, the timeout, the retries and the user agent
+	HttpConfig
+	DestPath string // local directory the bucket is mirrored into
+	Workers  int    // number of parallel downloads
+	Options  SyncOptions
+	// StateHandler is optional. Files that are missing locally but are marked as finished
+	// in the state handler (e.g. deleted after processing) are not downloaded again.
+	StateHandler *StateHandler
+}
+
+// ErrInvalidSyncOptions is returned when the sync options are invalid
+var ErrInvalidSyncOptions = errors.New("invalid sync options")
+
+// SyncOptions selects the parts of the snapshot that are synced.
+// The zero value syncs the complete snapshot.
+type SyncOptions struct {
+	EntityTypes     []FileEntityType // entity types to sync, all entity types if empty
+	UpdatedDateFrom string           // inclusive lower bound of the updated_date partitions, e.g. 2024-01-01
+	UpdatedDateTo   string           // inclusive upper bound of the updated_date partitions
+	SkipMergedIds   bool             // do not sync the merged ids files
+}
+
+// Validate checks the format of the dates
+func (o *SyncOptions) Validate() error {
+	for _, date := range []string{o.UpdatedDateFrom, o.UpdatedDateTo} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			return fmt.Errorf("%w: %s is not a date of the format YYYY-MM-DD", ErrInvalidSyncOptions, date)
+		}
+	}
+	return nil
+}
+
+// IncludesEntityType checks if the entity type is selected
+func (o *SyncOptions) IncludesEntityType(entityType FileEntityType) bool {
+	if len(o.EntityTypes) == 0 {
+		return true
+	}
+	return slices.Contains(o.EntityTypes, entityType)
+}
+
+// IncludesUpdatedDate checks if the updated date (e.g. 2023-05-16) is within the bounds
+func (o *SyncOptions) IncludesUpdatedDate(updatedDate string) bool {
+	if o.UpdatedDateFrom != "" && updatedDate < o.UpdatedDateFrom {
+		return false
+	}
+	if o.UpdatedDateTo != "" && updatedDate > o.UpdatedDateTo {
+		return false
+	}
+	return true
+}
+
+// includesFile checks if a part file is selected
+func (o *SyncOptions) includesFile(entityType FileEntityType, filePath string) bool {
+	updatedDate := strings.TrimPrefix(getUpdatedDate(filePath), "updated_date=")
+	return o.IncludesEntityType(entityType) && o.IncludesUpdatedDate(updatedDate)
+}
+
+// SnapshotPartition is an updated_date partition of an entity or a merged ids file
+type SnapshotPartition struct {
+	EntityType  FileEntityType
+	UpdatedDate string   // e.g. 2023-05-16, the merge date for merged ids files
+	MergedIds   bool     // true if the partition is a merged ids file
+	FilePaths   []string // local paths of the files that were added or removed
+}
+
+// SyncResult contains the partitions that were added or removed during a sync
+type SyncResult struct {
+	Added   []SnapshotPartition
+	Removed []SnapshotPartition
+}
+
+// AddedFilePaths returns the local paths of all added files in the order expected by Processor.ProcessFiles
+func (r *SyncResult) AddedFilePaths() (filePaths []string) {
+	for _, partition := range r.Added {
+		filePaths = append(filePaths, partition.FilePaths...)
+	}
+	return OrderByMergedIDsLast(filePaths)
+}
+
+// NewSnapshotDownloader creates a new downloader that mirrors the public bucket into destPath
+func NewSnapshotDownloader(destPath string) *SnapshotDownloader {
+	return &SnapshotDownloader{
+		HttpConfig: DefaultHttpConfig(),
+		DestPath:   destPath,
+		Workers:    4,
+	}
+}
+
 // Sync downloads the latest snapshot from openalex
-// "AWS CLI" installation required
-// https://docs.aws.amazon.com/cli/latest/userguide/getting-started-install.html
 // Note that the Snapshot has around 422GB and 1.6TB after uncompression
 func Sync(destPath string) (err error) {
-	logger := slog.With("destPath", destPath)
-	source := "s3://openalex"
-	arg := "--no-sign-request"
-	argDelete := "--delete"
-	dest := destPath
-
-	// aws sync copies new or modified files to the destination, but does not delete old files
-	downloadCmd := exec.Command("aws", "s3", "sync", source, dest, arg)
-	downloadCmd.Stdout = os.Stdout
-	err = downloadCmd.Run()
+	return NewSnapshotDownloader(destPath).Sync(context.Background())
+}
+
+// SyncWithOptions downloads the parts of the latest snapshot that are selected by the options
+func SyncWithOptions(destPath string, options SyncOptions) (err error) {
+	d := NewSnapshotDownloader(destPath)
+	d.Options = options
+	return d.Sync(context.Background())
+}
+
+// Sync downloads all files that are new or changed and deletes local files that are no longer part of the snapshot.
+// Only the entity types and partitions selected by the options are downloaded.
+// Partitions outside the date bounds are kept, if they are still part of the snapshot.
+// Cancelling the context stops the sync, interrupted downloads are resumed with the next sync.
+func (d *SnapshotDownloader) Sync(ctx context.Context) (err error) {
+	_, err = d.SyncIncremental(ctx)
+	return err
+}
+
+// SyncIncremental compares the remote manifests with the local directory and the state handler.
+// Only the files of new or changed partitions are downloaded.
+// The result contains the added and removed partitions, the added files can be passed to Processor.ProcessFiles.
+func (d *SnapshotDownloader) SyncIncremental(ctx context.Context) (result *SyncResult, err error) {
+	plan, err := d.Plan(ctx)
 	if err != nil {
-		logger.With("err", err).Error("error while downloading snapshot")
-		return err
+		return &SyncResult{}, err
 	}
+	return d.Apply(ctx, plan)
+}
 
-	// delete outdated data that exist in the destination but not in the source
-	deleteCmd := exec.Command("aws", "s3", "sync", source, dest, arg, argDelete)
-	deleteCmd.Stdout = os.Stdout
-	err = deleteCmd.Run()
+// Apply downloads and deletes the files of the plan.
+// The manifest of an entity is written after its part files were downloaded,
+// so that the local manifest always describes the local state.
+func (d *SnapshotDownloader) Apply(ctx context.Context, plan *SyncPlan) (result *SyncResult, err error) {
+	logger := slog.With("destPath", d.DestPath, "baseUrl", d.BaseUrl)
+	logger.Info("Start syncing snapshot")
+	result = &SyncResult{}
+
+	// the part files of the entities
+	for _, entityPlan := range plan.Entities {
+		err = d.applyEntity(ctx, entityPlan, false, result)
+		if err != nil {
+			logger.With("err", err).With("entityType", entityPlan.EntityType).Error("error while syncing entity")
+			return result, err
+		}
+	}
+	// the merged ids files
+	for _, entityPlan := range plan.Entities {
+		err = d.applyEntity(ctx, entityPlan, true, result)
+		if err != nil {
+			logger.With("err", err).With("entityType", entityPlan.EntityType).Error("error while syncing merged ids")
+			return result, err
+		}
+	}
+
+	logger.
+		With("added", len(result.Added)).
+		With("removed", len(result.Removed)).
+		Info("Finished syncing snapshot")
+	return result, nil
+}
+
+// applyEntity downloads and deletes either the part files or the merged ids files of an entity plan
+func (d *SnapshotDownloader) applyEntity(ctx context.Context, entityPlan *EntitySyncPlan, mergedIds bool, result *SyncResult) (err error) {
+	// download the new and changed files
+	var jobs []*downloadJob
+	for _, partition := range append(entityPlan.Add, entityPlan.Replace...) {
+		if partition.MergedIds != mergedIds {
+			continue
+		}
+		for _, file := range partition.Files {
+			jobs = append(jobs, &downloadJob{key: file.Key, localPath: file.LocalPath, size: file.Bytes})
+		}
+	}
+	added, err := d.downloadAll(ctx, jobs)
+	result.Added = append(result.Added, groupPartitions(entityPlan.EntityType, mergedIds, added)...)
 	if err != nil {
-		logger.With("err", err).Error("error while deleting outdated data")
 		return err
 	}
 
+	// write the manifest
+	if !mergedIds && entityPlan.manifestBytes != nil {
+		manifestPath := d.localPath(entityPlan.manifestKey)
+		err = os.MkdirAll(filepath.Dir(manifestPath), 0o755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(manifestPath, entityPlan.manifestBytes, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+
+	// delete the files that are no longer part of the snapshot
+	var outdated []string
+	for _, partition := range entityPlan.Delete {
+		if partition.MergedIds != mergedIds {
+			continue
+		}
+		for _, file := range partition.Files {
+			outdated = append(outdated, file.LocalPath)
+		}
+	}
+	removed, err := deleteFiles(outdated)
+	result.Removed = append(result.Removed, groupPartitions(entityPlan.EntityType, mergedIds, removed)...)
 	return err
 }
+
+// mergedIdsEntityType returns the entity type of a local merged ids file
+func (d *SnapshotDownloader) mergedIdsEntityType(localPath string) (FileEntityType, error) {
+	relPath, err := filepath.Rel(d.DestPath, localPath)
+	if err != nil {
+		return "", err
+	}
+	return GetEntityType(relPath)
+}
+
+// isUpToDate checks if a local file does not need to be downloaded
+func (d *SnapshotDownloader) isUpToDate(localPath string, size int64) bool {
+	return isFileOfSize(localPath, size) || d.isProcessedAndDeleted(localPath)
+}
+
+// isProcessedAndDeleted checks if a file does not exist because it was deleted after it was processed,
+// which is only known with a StateHandler
+func (d *SnapshotDownloader) isProcessedAndDeleted(localPath string) bool {
+	if d.StateHandler == nil {
+		return false
+	}
+	if _, err := os.Stat(localPath); !errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+	finished, err := d.StateHandler.IsEntityFileFinished(localPath)
+	if err != nil {
+		slog.With("err", err).With("localPath", localPath).Error("error while reading the state of the file")
+		return false
+	}
+	return finished
+}
+
+// groupPartitions groups file paths by their partition, the order of the files is preserved
+func groupPartitions(entityType FileEntityType, mergedIds bool, filePaths []string) (partitions []SnapshotPartition) {
+	index := make(map[string]int)
+	for _, filePath := range filePaths {
+		updatedDate := partitionDate(mergedIds, filePath)
+		key := filepath.Dir(filePath) + "::" + updatedDate
+		i, ok := index[key]
+		if !ok {
+			i = len(partitions)
+			index[key] = i
+			partitions = append(partitions, SnapshotPartition{
+				EntityType:  entityType,
+				UpdatedDate: updatedDate,
+				MergedIds:   mergedIds,
+			})
+		}
+		partitions[i].FilePaths = append(partitions[i].FilePaths, filePath)
+	}
+	return partitions
+}
+
+// objectUrl returns the https url of an object key
+func (d *SnapshotDownloader) objectUrl(key string) string {
+	return d.url(key)
+}
+
+// localPath returns the local path of an object key
+func (d *SnapshotDownloader) localPath(key string) string {
+	return filepath.Join(d.DestPath, filepath.FromSlash(key))
+}
+
+// fetch reads an object into memory
+func (d *SnapshotDownloader) fetch(ctx context.Context, key string) (data []byte, err error) {
+	return d.getBytes(ctx, d.objectUrl(key))
+}
+
+// downloadJob is a file that needs to be downloaded
+type downloadJob struct {
+	key       string
+	localPath string
+	size      int64 // expected size, 0 if unknown
+	err       error
+}
+
+// downloadAll downloads the files with the configured number of workers.
+// It returns the local paths of the downloaded files in the order of the jobs
+// and the joined errors of the failed downloads.
+// Failed downloads are retried and resumed up to MaxRetries times.
+func (d *SnapshotDownloader) downloadAll(ctx context.Context, jobs []*downloadJob) (downloaded []string, err error) {
+	workers := d.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	queue := make(chan *downloadJob)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				logger := slog.With("key", job.key)
+				logger.Info("Downloading file")
+				job.err = d.retry(ctx, logger, func() error {
+					return d.download(ctx, job.key, job.localPath, job.size)
+				})
+				if job.err != nil {
+					logger.With("err", job.err).Error("error while downloading file")
+				}
+			}
+		}()
+	}
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+
+	var errs []error
+	for _, job := range jobs {
+		if job.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", job.key, job.err))
+			continue
+		}
+		downloaded = append(downloaded, job.localPath)
+	}
+	return downloaded, errors.Join(errs...)
+}
+
+// partialPath returns the path of the temporary file of a download.
+// It does not contain ".gz", so that it is never picked up by Processor.GetFiles.
+func partialPath(localPath string) string {
+	return strings.TrimSuffix(localPath, ".gz") + ".partial"
+}
+
+// validatorPath returns the path of the file that stores the ETag or Last-Modified header
+// of the remote object that a partial download belongs to.
+func validatorPath(tmpPath string) string {
+	return tmpPath + ".etag"
+}
+
+// responseValidator returns the ETag of the response or the Last-Modified header if there is no ETag
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// storeValidator stores the validator of the remote object next to the partial download.
+// An empty validator removes the stored one, so that the download is never resumed.
+func storeValidator(tmpPath string, validator string) error {
+	if validator == "" {
+		err := os.Remove(validatorPath(tmpPath))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.WriteFile(validatorPath(tmpPath), []byte(validator), 0o644)
+}
+
+// contentRangeStart returns the first byte of a Content-Range header, e.g. 100 of "bytes 100-199/200"
+func contentRangeStart(contentRange string) (start int64, ok bool) {
+	rangeSpec, found := strings.CutPrefix(contentRange, "bytes ")
+	if !found {
+		return 0, false
+	}
+	first, _, found := strings.Cut(rangeSpec, "-")
+	if !found {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	return start, err == nil
+}
+
+// removePartial removes a partial download and its validator
+func removePartial(tmpPath string) {
+	_ = os.Remove(tmpPath)
+	_ = os.Remove(validatorPath(tmpPath))
+}
+
+// isPartialPath checks if the path is a temporary file of an unfinished download,
+// which is kept to resume the download and is not part of the snapshot
+func isPartialPath(path string) bool {
+	return strings.Contains(filepath.Base(path), ".partial")
+}
+
+// download writes an object to the local path.
+// The data is written to a temporary file first, which is renamed when the download is complete.
+// If a temporary file of a previous download exists, the download is resumed with a HTTP Range request.
+// The If-Range header makes sure that the remote object was not replaced since the download started,
+// otherwise the server sends the whole object and the download starts over.
+// Errors that can not be solved by a retry are returned as backoff.Permanent errors.
+func (d *SnapshotDownloader) download(ctx context.Context, key string, localPath string, size int64) (err error) {
+	logger := slog.With("key", key)
+	err = os.MkdirAll(filepath.Dir(localPath), 0o755)
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	tmpPath := partialPath(localPath)
+
+	// check if there is a partial download
+	var offset int64
+	if info, errStat := os.Stat(tmpPath); errStat == nil {
+		offset = info.Size()
+	}
+	if size > 0 && offset > size {
+		offset = 0
+	}
+	validator, errValidator := os.ReadFile(validatorPath(tmpPath))
+	if offset > 0 && (errValidator != nil || len(validator) == 0) {
+		// the partial file can not be matched to the remote object
+		logger.Info("Restarting download without a validator")
+		offset = 0
+	}
+
+	if size <= 0 || offset < size {
+		// a stalled connection is cancelled and the download is resumed by the retry
+		reqCtx, idle, cancel := withIdleTimeout(ctx, d.IdleTimeout)
+		defer cancel()
+		req, errReq := d.newRequest(reqCtx, d.objectUrl(key))
+		if errReq != nil {
+			return backoff.Permanent(errReq)
+		}
+		if offset > 0 {
+			logger.With("offset", offset).Info("Resuming download")
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			req.Header.Set("If-Range", string(validator))
+		}
+		resp, errDo := d.client().Do(req)
+		if errDo != nil {
+			return idle.err(errDo)
+		}
+		defer resp.Body.Close()
+
+		flags := os.O_CREATE | os.O_WRONLY
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			// a range that does not start at the end of the partial file would corrupt it
+			if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+				removePartial(tmpPath)
+				logger.With("contentRange", resp.Header.Get("Content-Range")).With("offset", offset).Error("Unexpected content range")
+				return fmt.Errorf("%w: content range %q does not start at %d", ErrIncompleteDownload, resp.Header.Get("Content-Range"), offset)
+			}
+			flags |= os.O_APPEND
+		case http.StatusOK:
+			// the server ignored the range or the remote object changed, start from the beginning
+			flags |= os.O_TRUNC
+			if errStore := storeValidator(tmpPath, responseValidator(resp)); errStore != nil {
+				return backoff.Permanent(errStore)
+			}
+		case http.StatusRequestedRangeNotSatisfiable:
+			// the partial file does not match the remote file, the retry starts over
+			removePartial(tmpPath)
+			logger.With("statusCode", resp.StatusCode).Error("Failed to resume download")
+			return fmt.Errorf("%w: %d", ErrStatusNotOK, resp.StatusCode)
+		default:
+			logger.With("statusCode", resp.StatusCode).Error("Failed to fetch S3 object")
+			return statusError(resp.StatusCode)
+		}
+
+		file, errOpen := os.OpenFile(tmpPath, flags, 0o644)
+		if errOpen != nil {
+			return backoff.Permanent(errOpen)
+		}
+		_, err = io.Copy(file, idle.reader(resp.Body))
+		if errClose := file.Close(); err == nil {
+			err = errClose
+		}
+		if err != nil {
+			// keep the partial file to resume the download later
+			return idle.err(err)
+		}
+	}
+
+	if size > 0 && !isFileOfSize(tmpPath, size) {
+		if info, errStat := os.Stat(tmpPath); errStat == nil && info.Size() > size {
+			removePartial(tmpPath)
+		}
+		return ErrIncompleteDownload
+	}
+	err = os.Rename(tmpPath, localPath)
+	if err != nil {
+		return err
+	}
+	_ = os.Remove(validatorPath(tmpPath))
+	return nil
+}
+
+// bucketObject is an object of the S3 ListObjectsV2 response
+type bucketObject struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+}
+
+// listBucketResult is the S3 ListObjectsV2 response
+type listBucketResult struct {
+	IsTruncated           bool           `xml:"IsTruncated"`
+	NextContinuationToken string         `xml:"NextContinuationToken"`
+	Contents              []bucketObject `xml:"Contents"`
+}
+
+// listBucket lists all objects below the prefix using the S3 ListObjectsV2 API
+func (d *SnapshotDownloader) listBucket(ctx context.Context, prefix string) (objects []bucketObject, err error) {
+	continuationToken := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		body, errGet := d.getBytes(ctx, strings.TrimSuffix(d.BaseUrl, "/")+"/?"+query.Encode())
+		if errGet != nil {
+			return nil, errGet
+		}
+		var result listBucketResult
+		err = xml.Unmarshal(body, &result)
+		if err != nil {
+			slog.With("err", err).With("prefix", prefix).Error("Failed to decode bucket listing")
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			// skip directory markers
+			if strings.HasSuffix(object.Key, "/") {
+				continue
+			}
+			objects = append(objects, object)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// deleteFiles removes the files and their parent directories, if they are empty afterwards.
+// It returns the paths of the deleted files.
+func deleteFiles(filePaths []string) (deleted []string, err error) {
+	for _, path := range filePaths {
+		slog.With("path", path).Info("Deleting outdated file")
+		err = os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return deleted, err
+		}
+		deleted = append(deleted, path)
+		// remove the partition directory if it is empty now, ignore the error if it is not
+		_ = os.Remove(filepath.Dir(path))
+	}
+	return deleted, nil
+}
+
+// s3UrlToKey converts a s3://openalex/... url of the manifest into an object key
+func s3UrlToKey(s3Url string) string {
+	return strings.TrimPrefix(s3Url, snapshotS3Prefix)
+}
+
+// isFileOfSize checks if the file exists and has the given size
+func isFileOfSize(path string, size int64) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return !info.IsDir() && info.Size() == size
+}
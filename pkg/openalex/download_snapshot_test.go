@@ -1,9 +1,22 @@
 package openalex
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // WARNING
@@ -30,3 +43,498 @@ func TestSync(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// gzipBytes compresses the lines into a gz file
+func gzipBytes(t *testing.T, lines ...string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	for _, line := range lines {
+		_, err := w.Write([]byte(line + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newTestBucket serves the objects like the public openalex bucket.
+// The manifests are generated from the part files of the objects.
+func newTestBucket(t *testing.T, objects map[string][]byte) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		if key == "" && r.URL.Query().Get("list-type") == "2" {
+			prefix := r.URL.Query().Get("prefix")
+			w.Write([]byte("<ListBucketResult><IsTruncated>false</IsTruncated>"))
+			for k, v := range objects {
+				if strings.HasPrefix(k, prefix) {
+					fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", k, len(v))
+				}
+			}
+			w.Write([]byte("</ListBucketResult>"))
+			return
+		}
+		if strings.HasSuffix(key, "/manifest") {
+			var entries []string
+			entityDir := strings.TrimSuffix(key, "manifest")
+			for k, v := range objects {
+				if strings.HasPrefix(k, entityDir) {
+					entries = append(entries, fmt.Sprintf(`{"url":"%s%s","meta":{"content_length":%d,"record_count":%d}}`,
+						snapshotS3Prefix, k, len(v), testRecordCount(v)))
+				}
+			}
+			manifest := `{"entries":[` + strings.Join(entries, ",") + `]}`
+			w.Write([]byte(manifest))
+			return
+		}
+		data, ok := objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", testETag(data))
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// testETag returns a strong ETag of the data
+func testETag(data []byte) string {
+	return fmt.Sprintf(`"%x"`, sha256.Sum256(data))
+}
+
+// testRecordCount counts the lines of a gz file
+func testRecordCount(data []byte) int {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return 0
+	}
+	content, _ := io.ReadAll(r)
+	return bytes.Count(content, []byte("\n"))
+}
+
+func TestSnapshotDownloaderSync(t *testing.T) {
+	objects := map[string][]byte{
+		"data/works/updated_date=2023-05-16/part_000.gz":   gzipBytes(t, `{"id":"W1"}`, `{"id":"W2"}`),
+		"data/authors/updated_date=2023-04-21/part_000.gz": gzipBytes(t, `{"id":"A1"}`),
+		"data/merged_ids/authors/2023-04-13.csv.gz":        gzipBytes(t, "merge_date,id,merge_into_id", "2023-04-13,A2,A1"),
+	}
+	server := newTestBucket(t, objects)
+
+	destPath := t.TempDir()
+	// a partition that is no longer part of the snapshot
+	outdatedPath := filepath.Join(destPath, "data", "works", "updated_date=2020-01-01", "part_000.gz")
+	err := os.MkdirAll(filepath.Dir(outdatedPath), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(outdatedPath, []byte("outdated"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewSnapshotDownloader(destPath)
+	d.BaseUrl = server.URL
+	err = d.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, data := range objects {
+		localData, errRead := os.ReadFile(filepath.Join(destPath, filepath.FromSlash(key)))
+		if errRead != nil {
+			t.Error(errRead)
+			continue
+		}
+		if !bytes.Equal(localData, data) {
+			t.Error("content mismatch", key)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(destPath, "data", "works", "manifest")); err != nil {
+		t.Error("manifest not written", err)
+	}
+	if _, err = os.Stat(filepath.Dir(outdatedPath)); !os.IsNotExist(err) {
+		t.Error("outdated partition was not deleted")
+	}
+}
+
+func TestSnapshotDownloaderSyncIncremental(t *testing.T) {
+	objects := map[string][]byte{
+		"data/works/updated_date=2023-05-16/part_000.gz": gzipBytes(t, `{"id":"W1"}`),
+		"data/works/updated_date=2023-05-16/part_001.gz": gzipBytes(t, `{"id":"W2"}`),
+	}
+	server := newTestBucket(t, objects)
+	destPath := t.TempDir()
+	d := NewSnapshotDownloader(destPath)
+	d.BaseUrl = server.URL
+	d.StateHandler = NewStateHandler("log.db", t.TempDir(), destPath)
+
+	result, err := d.SyncIncremental(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Added) != 1 || len(result.Added[0].FilePaths) != 2 || result.Added[0].UpdatedDate != "2023-05-16" {
+		t.Fatal("unexpected added partitions", result.Added)
+	}
+
+	// nothing changed
+	result, err = d.SyncIncremental(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Added) != 0 || len(result.Removed) != 0 {
+		t.Fatal("expected no changes", result)
+	}
+
+	// a processed file that was deleted locally is not downloaded again
+	processedPath := filepath.Join(destPath, "data", "works", "updated_date=2023-05-16", "part_000.gz")
+	_, err = d.StateHandler.RegisterOrSkipEntityFile(processedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.StateHandler.MarkEntityFileAsFinished()
+	err = os.Remove(processedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new partition is published and an old one is removed
+	objects["data/works/updated_date=2023-06-01/part_000.gz"] = gzipBytes(t, `{"id":"W3"}`)
+	delete(objects, "data/works/updated_date=2023-05-16/part_001.gz")
+	result, err = d.SyncIncremental(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	filePaths := result.AddedFilePaths()
+	if len(filePaths) != 1 || getUpdatedDate(filePaths[0]) != "updated_date=2023-06-01" {
+		t.Error("unexpected added files", filePaths)
+	}
+	if len(result.Removed) != 1 || result.Removed[0].EntityType != WorksFileEntityType || result.Removed[0].UpdatedDate != "2023-05-16" {
+		t.Error("unexpected removed partitions", result.Removed)
+	}
+}
+
+func TestSnapshotDownloaderResume(t *testing.T) {
+	var lines []string
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, fmt.Sprintf(`{"id":"W%d"}`, i))
+	}
+	objects := map[string][]byte{
+		"data/works/updated_date=2023-05-16/part_000.gz": gzipBytes(t, lines...),
+		"data/works/updated_date=2023-05-16/part_001.gz": gzipBytes(t, lines[:10]...),
+		"data/works/updated_date=2023-05-16/part_002.gz": gzipBytes(t, lines[10:20]...),
+	}
+	bucket := newTestBucket(t, objects)
+	var rangeRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			rangeRequests.Add(1)
+		}
+		bucket.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	destPath := t.TempDir()
+	localPath := filepath.Join(destPath, "data", "works", "updated_date=2023-05-16", "part_000.gz")
+	// simulate an interrupted download
+	data := objects["data/works/updated_date=2023-05-16/part_000.gz"]
+	err := os.MkdirAll(filepath.Dir(localPath), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(partialPath(localPath), data[:len(data)/2], 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(validatorPath(partialPath(localPath)), []byte(testETag(data)), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewSnapshotDownloader(destPath)
+	d.BaseUrl = server.URL
+	d.Workers = 3
+	err = d.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rangeRequests.Load() != 1 {
+		t.Error("expected one range request, got", rangeRequests.Load())
+	}
+	localData, err := os.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(localData, data) {
+		t.Error("resumed file does not match")
+	}
+	if _, err = os.Stat(partialPath(localPath)); !os.IsNotExist(err) {
+		t.Error("partial file was not renamed")
+	}
+	if _, err = os.Stat(validatorPath(partialPath(localPath))); !os.IsNotExist(err) {
+		t.Error("validator was not removed")
+	}
+}
+
+func TestSnapshotDownloaderResumeReplacedObject(t *testing.T) {
+	key := "data/works/updated_date=2023-05-16/part_000.gz"
+	old := gzipBytes(t, `{"id":"W1"}`, `{"id":"W2"}`)
+	replaced := gzipBytes(t, `{"id":"W1"}`, `{"id":"W2"}`, `{"id":"W3"}`, `{"id":"W4"}`)
+	server := newTestBucket(t, map[string][]byte{key: replaced})
+
+	destPath := t.TempDir()
+	localPath := filepath.Join(destPath, filepath.FromSlash(key))
+	// the interrupted download belongs to the old object
+	err := os.MkdirAll(filepath.Dir(localPath), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(partialPath(localPath), old[:len(old)/2], 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(validatorPath(partialPath(localPath)), []byte(testETag(old)), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewSnapshotDownloader(destPath)
+	d.BaseUrl = server.URL
+	err = d.download(context.Background(), key, localPath, int64(len(replaced)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	localData, err := os.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(localData, replaced) {
+		t.Error("expected the replaced object, not a mix of both objects")
+	}
+}
+
+func TestSnapshotDownloaderIdleTimeout(t *testing.T) {
+	key := "data/works/updated_date=2023-05-16/part_000.gz"
+	var lines []string
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, fmt.Sprintf(`{"id":"W%d"}`, i))
+	}
+	data := gzipBytes(t, lines...)
+	bucket := newTestBucket(t, map[string][]byte{key: data})
+	var stalled atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first download stalls after half of the file
+		if strings.HasSuffix(r.URL.Path, ".gz") && stalled.CompareAndSwap(false, true) {
+			w.Header().Set("ETag", testETag(data))
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data[:len(data)/2])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		bucket.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	destPath := t.TempDir()
+	localPath := filepath.Join(destPath, filepath.FromSlash(key))
+	d := NewSnapshotDownloader(destPath)
+	d.BaseUrl = server.URL
+	d.IdleTimeout = 50 * time.Millisecond
+	err := d.download(context.Background(), key, localPath, int64(len(data)))
+	if !errors.Is(err, ErrDownloadStalled) {
+		t.Fatal("expected a stalled download", err)
+	}
+	err = d.download(context.Background(), key, localPath, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	localData, err := os.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(localData, data) {
+		t.Error("resumed file does not match")
+	}
+}
+
+func TestSnapshotDownloaderResumeWrongRange(t *testing.T) {
+	key := "data/works/updated_date=2023-05-16/part_000.gz"
+	data := gzipBytes(t, `{"id":"W1"}`, `{"id":"W2"}`, `{"id":"W3"}`)
+	bucket := newTestBucket(t, map[string][]byte{key: data})
+	var rangeRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a proxy that answers the range request with the start of the file
+		if r.Header.Get("Range") != "" {
+			rangeRequests.Add(1)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(data)-1, len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data)
+			return
+		}
+		bucket.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	destPath := t.TempDir()
+	localPath := filepath.Join(destPath, filepath.FromSlash(key))
+	err := os.MkdirAll(filepath.Dir(localPath), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(partialPath(localPath), data[:len(data)/2], 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(validatorPath(partialPath(localPath)), []byte(testETag(data)), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewSnapshotDownloader(destPath)
+	d.BaseUrl = server.URL
+	err = d.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rangeRequests.Load() != 1 {
+		t.Error("expected one range request, got", rangeRequests.Load())
+	}
+	localData, err := os.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(localData, data) {
+		t.Error("expected the download to start over")
+	}
+}
+
+func TestContentRangeStart(t *testing.T) {
+	for contentRange, expected := range map[string]int64{"bytes 100-199/200": 100, "bytes 0-0/*": 0, "": -1, "bytes */200": -1, "items 1-2/3": -1} {
+		start, ok := contentRangeStart(contentRange)
+		if !ok {
+			start = -1
+		}
+		if start != expected {
+			t.Error("unexpected start", contentRange, start)
+		}
+	}
+}
+
+func TestSnapshotDownloaderSyncOptions(t *testing.T) {
+	objects := map[string][]byte{
+		"data/works/updated_date=2023-05-16/part_000.gz":    gzipBytes(t, `{"id":"W1"}`),
+		"data/works/updated_date=2024-02-01/part_000.gz":    gzipBytes(t, `{"id":"W2"}`),
+		"data/works/updated_date=2024-09-01/part_000.gz":    gzipBytes(t, `{"id":"W3"}`),
+		"data/topics/updated_date=2024-02-01/part_000.gz":   gzipBytes(t, `{"id":"T1"}`),
+		"data/concepts/updated_date=2024-02-01/part_000.gz": gzipBytes(t, `{"id":"C1"}`),
+		"data/merged_ids/authors/2023-04-13.csv.gz":         gzipBytes(t, "merge_date,id,merge_into_id"),
+	}
+	server := newTestBucket(t, objects)
+	destPath := t.TempDir()
+
+	// a partition outside the bounds that is still part of the snapshot is kept
+	keptPath := filepath.Join(destPath, "data", "works", "updated_date=2023-05-16", "part_000.gz")
+	err := os.MkdirAll(filepath.Dir(keptPath), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keptPath, []byte("kept"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewSnapshotDownloader(destPath)
+	d.BaseUrl = server.URL
+	d.Options = SyncOptions{
+		EntityTypes:     []FileEntityType{WorksFileEntityType, TopicsFileEntityType},
+		UpdatedDateFrom: "2024-01-01",
+		UpdatedDateTo:   "2024-06-30",
+		SkipMergedIds:   true,
+	}
+	result, err := d.SyncIncremental(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	filePaths := result.AddedFilePaths()
+	if len(filePaths) != 2 {
+		t.Fatal("unexpected added files", filePaths)
+	}
+	for _, filePath := range filePaths {
+		if getUpdatedDate(filePath) != "updated_date=2024-02-01" || strings.Contains(filePath, "concepts") {
+			t.Error("file should not be synced", filePath)
+		}
+	}
+	if _, err = os.Stat(keptPath); err != nil {
+		t.Error("partition outside the bounds was deleted")
+	}
+	if _, err = os.Stat(filepath.Join(destPath, "data", "merged_ids")); !os.IsNotExist(err) {
+		t.Error("merged ids should be skipped")
+	}
+
+	d.Options.UpdatedDateFrom = "2024-13-01"
+	_, err = d.SyncIncremental(context.Background())
+	if !errors.Is(err, ErrInvalidSyncOptions) {
+		t.Error("expected invalid sync options", err)
+	}
+}
+
+func TestSnapshotDownloaderSyncOptionsManifest(t *testing.T) {
+	objects := map[string][]byte{
+		"data/works/updated_date=2023-05-16/part_000.gz": gzipBytes(t, `{"id":"W1"}`),
+		"data/works/updated_date=2024-02-01/part_000.gz": gzipBytes(t, `{"id":"W2"}`),
+		"data/works/updated_date=2024-09-01/part_000.gz": gzipBytes(t, `{"id":"W3"}`, `{"id":"W4"}`),
+	}
+	server := newTestBucket(t, objects)
+	destPath := t.TempDir()
+	d := NewSnapshotDownloader(destPath)
+	d.BaseUrl = server.URL
+	d.Options = SyncOptions{
+		EntityTypes:     []FileEntityType{WorksFileEntityType},
+		UpdatedDateFrom: "2024-01-01",
+		SkipMergedIds:   true,
+	}
+	_, err := d.SyncIncremental(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the local manifest only lists the synced partitions
+	manifest, err := ReadManifestFromFile(ManifestUrlWorks.LocalPath(filepath.Join(destPath, "data")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Entries) != 2 || manifest.Meta.RecordCount != 3 {
+		t.Errorf("unexpected manifest %+v", manifest)
+	}
+
+	// the partial mirror can be processed with its manifest
+	var lines []string
+	p := Processor{
+		DirectoryPath: filepath.Join(destPath, "data"),
+		UseManifests:  true,
+		LineHandler: func(filePath string, line string) error {
+			lines = append(lines, line)
+			return nil
+		},
+	}
+	if _, err = p.ProcessDirectory(); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 3 {
+		t.Error("unexpected lines", lines)
+	}
+
+	// the manifest matches the files on disk
+	report, err := d.VerifyFiles(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Verified != 2 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
@@ -1,6 +1,43 @@
 package openalex
 
+import "fmt"
+
 type Entity interface {
 	GetType() string
 	GetID() string
 }
+
+// EntityHandler is a function that handles a decoded entity
+type EntityHandler func(fileEntityType FileEntityType, entity Entity) error
+
+// PrintEntityHandler is a function that prints a decoded entity
+func PrintEntityHandler(fileEntityType FileEntityType, entity Entity) error {
+	fmt.Println(fileEntityType, entity.GetID())
+	return nil
+}
+
+// NewEntity returns an empty entity of the entity type
+func NewEntity(entityType FileEntityType) (Entity, error) {
+	switch entityType {
+	case AuthorsFileEntityType:
+		return &Author{}, nil
+	case ConceptsFileEntityType:
+		return &Concept{}, nil
+	case FundersFileEntityType:
+		return &Funder{}, nil
+	case InstitutionsFileEntityType:
+		return &Institution{}, nil
+	case PublishersFileEntityType:
+		return &Publisher{}, nil
+	case SourcesFileEntityType:
+		return &Source{}, nil
+	case WorksFileEntityType:
+		return &Work{}, nil
+	case TopicsFileEntityType:
+		return &Topic{}, nil
+	case DomainsFileEntityType:
+		return &Domain{}, nil
+	default:
+		return nil, ErrUnsupportedFileType
+	}
+}
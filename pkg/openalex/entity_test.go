@@ -0,0 +1,15 @@
+package openalex
+
+import "testing"
+
+func TestEntityGetType(t *testing.T) {
+	for _, entityType := range ApiEntityTypes {
+		entity, err := NewEntity(entityType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entity.GetType() != string(entityType) {
+			t.Error("unexpected type", entityType, entity.GetType())
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package openalex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// DefaultUserAgent is the user agent of the requests if none is configured
+const DefaultUserAgent = "go-openalex"
+
+// ErrDownloadStalled is returned when no bytes arrived for the IdleTimeout of a download
+var ErrDownloadStalled = errors.New("download stalled")
+
+// HttpConfig configures the HTTP requests to the snapshot bucket and the REST API.
+// It is shared by the SnapshotDownloader, the Client and the manifest functions,
+// so a single config can be injected to use a mirror or a local test server.
+type HttpConfig struct {
+	BaseUrl    string       // base url of the bucket or the api, e.g. a mirror or a local test server
+	HttpClient *http.Client // client that sends the requests, http.DefaultClient if nil
+	// Timeout limits requests for manifests, bucket listings and api responses, 0 disables the timeout.
+	// Part file downloads are not limited, as they are resumed after a cancellation.
+	Timeout time.Duration
+	// IdleTimeout cancels a part file download if no bytes arrive for this duration, 0 disables it.
+	// The download is retried and resumed like any other failed download.
+	IdleTimeout time.Duration
+	MaxRetries  uint64 // maximal number of retries of failed requests
+	UserAgent   string // DefaultUserAgent if empty
+}
+
+// DefaultHttpConfig returns the config that is used for the public snapshot bucket
+func DefaultHttpConfig() HttpConfig {
+	return HttpConfig{
+		BaseUrl:     DefaultSnapshotBaseUrl,
+		HttpClient:  &http.Client{},
+		Timeout:     60 * time.Second,
+		IdleTimeout: 60 * time.Second,
+		MaxRetries:  5,
+		UserAgent:   DefaultUserAgent,
+	}
+}
+
+// client returns the configured http client
+func (c *HttpConfig) client() *http.Client {
+	if c.HttpClient == nil {
+		return http.DefaultClient
+	}
+	return c.HttpClient
+}
+
+// url joins the base url and the path
+func (c *HttpConfig) url(path string) string {
+	return strings.TrimSuffix(c.BaseUrl, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// newRequest creates a GET request with the user agent
+func (c *HttpConfig) newRequest(ctx context.Context, rawUrl string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	userAgent := c.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	return req, nil
+}
+
+// retry calls the operation until it succeeds, returns a permanent error,
+// the context is cancelled or MaxRetries is reached
+func (c *HttpConfig) retry(ctx context.Context, logger *slog.Logger, operation backoff.Operation) error {
+	notify := func(err error, wait time.Duration) {
+		logger.With("err", err).With("wait", wait).Warn("Retrying request")
+	}
+	b := backoff.WithContext(backoff.WithMaxRetries(newExponentialBackOff(), c.MaxRetries), ctx)
+	return backoff.RetryNotify(operation, b, notify)
+}
+
+// newExponentialBackOff returns an exponential backoff without a max elapsed time,
+// so that long downloads are still retried and only MaxRetries limits the retries
+func newExponentialBackOff() *backoff.ExponentialBackOff {
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = 0
+	return b
+}
+
+// statusError converts an unexpected status code into an error.
+// Too many requests and server errors are retried, all other status codes are permanent errors.
+func statusError(statusCode int) error {
+	err := fmt.Errorf("%w: %d", ErrStatusNotOK, statusCode)
+	if statusCode == http.StatusTooManyRequests || statusCode >= 500 {
+		return err
+	}
+	return backoff.Permanent(err)
+}
+
+// getBytes sends a GET request with the timeout and retries and returns the body
+func (c *HttpConfig) getBytes(ctx context.Context, rawUrl string) (body []byte, err error) {
+	logger := slog.With("url", rawUrl)
+	err = c.retry(ctx, logger, func() error {
+		reqCtx := ctx
+		if c.Timeout > 0 {
+			var cancel context.CancelFunc
+			reqCtx, cancel = context.WithTimeout(ctx, c.Timeout)
+			defer cancel()
+		}
+		req, errReq := c.newRequest(reqCtx, rawUrl)
+		if errReq != nil {
+			return backoff.Permanent(errReq)
+		}
+		resp, errDo := c.client().Do(req)
+		if errDo != nil {
+			return errDo
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return statusError(resp.StatusCode)
+		}
+		body, err = io.ReadAll(resp.Body)
+		return err
+	})
+	if err != nil {
+		logger.With("err", err).Error("Failed to fetch url")
+		return nil, err
+	}
+	return body, nil
+}
+
+// idleTimeout cancels a request when no bytes arrive for the timeout
+type idleTimeout struct {
+	timeout time.Duration
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+// withIdleTimeout returns a context that is cancelled when the idle timeout expires.
+// The timer starts immediately and is reset by every read of a reader that is wrapped with idleTimeout.reader.
+// A timeout of 0 disables it.
+func withIdleTimeout(ctx context.Context, timeout time.Duration) (context.Context, *idleTimeout, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	t := &idleTimeout{timeout: timeout}
+	if timeout > 0 {
+		t.timer = time.AfterFunc(timeout, func() {
+			t.stalled.Store(true)
+			cancel()
+		})
+	}
+	return ctx, t, func() {
+		if t.timer != nil {
+			t.timer.Stop()
+		}
+		cancel()
+	}
+}
+
+// reader wraps a reader, so that every read that returns bytes resets the timer
+func (t *idleTimeout) reader(reader io.Reader) io.Reader {
+	if t.timer == nil {
+		return reader
+	}
+	return &idleReader{reader: reader, idle: t}
+}
+
+// err wraps the error with ErrDownloadStalled if the idle timeout expired
+func (t *idleTimeout) err(err error) error {
+	if err != nil && t.stalled.Load() {
+		return fmt.Errorf("%w after %s: %w", ErrDownloadStalled, t.timeout, err)
+	}
+	return err
+}
+
+// idleReader resets the idle timeout on every read that returns bytes
+type idleReader struct {
+	reader io.Reader
+	idle   *idleTimeout
+}
+
+// Read reads from the underlying reader
+func (r *idleReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	if n > 0 {
+		r.idle.timer.Reset(r.idle.timeout)
+	}
+	return n, err
+}
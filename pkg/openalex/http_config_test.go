@@ -0,0 +1,154 @@
+package openalex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+func TestFetchManifest(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "test-agent" {
+			t.Error("unexpected user agent", r.Header.Get("User-Agent"))
+		}
+		if r.URL.Path != "/data/works/manifest" {
+			calls.Add(1)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"entries": [{"url": "s3://openalex/data/works/updated_date=2023-05-01/part_000.gz", "meta": {"content_length": 1, "record_count": 2}}]}`))
+	}))
+	defer server.Close()
+	config := DefaultHttpConfig()
+	config.BaseUrl = server.URL
+	config.UserAgent = "test-agent"
+
+	manifest, err := FetchManifest(context.Background(), config, ManifestUrlWorks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Entries) != 1 || calls.Load() != 2 {
+		t.Error("unexpected manifest", manifest.Entries, calls.Load())
+	}
+
+	// not found is not retried
+	calls.Store(0)
+	_, err = FetchManifest(context.Background(), config, ManifestUrlAuthors)
+	if !errors.Is(err, ErrStatusNotOK) || calls.Load() != 1 {
+		t.Error("expected a single failed request", err, calls.Load())
+	}
+}
+
+func TestFetchManifestUrls(t *testing.T) {
+	manifest := `{"entries": []}`
+	var mirrorCalls, otherCalls atomic.Int32
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorCalls.Add(1)
+		if r.URL.Path != "/data/works/manifest" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(manifest))
+	}))
+	defer mirror.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otherCalls.Add(1)
+		if r.URL.Path != "/snapshot/works/manifest" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(manifest))
+	}))
+	defer other.Close()
+	config := DefaultHttpConfig()
+	config.BaseUrl = mirror.URL
+
+	// the OpenAlex bucket and keys are read from the base url
+	for _, manifestUrl := range []ManifestUrl{ManifestUrlWorks, "s3://openalex/data/works/manifest", "data/works/manifest"} {
+		if _, err := FetchManifest(context.Background(), config, manifestUrl); err != nil {
+			t.Error("unexpected error", manifestUrl, err)
+		}
+	}
+	if mirrorCalls.Load() != 3 || otherCalls.Load() != 0 {
+		t.Error("expected the mirror", mirrorCalls.Load(), otherCalls.Load())
+	}
+
+	// other hosts are read from their own host
+	if _, err := FetchManifest(context.Background(), config, ManifestUrl(other.URL+"/snapshot/works/manifest")); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if mirrorCalls.Load() != 3 || otherCalls.Load() != 1 {
+		t.Error("expected the other host", mirrorCalls.Load(), otherCalls.Load())
+	}
+
+	// other buckets are not supported
+	for _, manifestUrl := range []ManifestUrl{"s3://other/data/works/manifest", "ftp://openalex.s3.amazonaws.com/data/works/manifest"} {
+		if _, err := FetchManifest(context.Background(), config, manifestUrl); !errors.Is(err, ErrUnsupportedManifestUrl) {
+			t.Error("expected an unsupported manifest url", manifestUrl, err)
+		}
+	}
+}
+
+func TestHttpConfigTimeoutAndCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	config := DefaultHttpConfig()
+	config.BaseUrl = server.URL
+	config.Timeout = 50 * time.Millisecond
+	config.MaxRetries = 0
+
+	start := time.Now()
+	_, err := FetchManifest(context.Background(), config, ManifestUrlWorks)
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 2*time.Second {
+		t.Error("expected a timeout", err, time.Since(start))
+	}
+
+	config.Timeout = 0
+	config.MaxRetries = 5
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	d := NewSnapshotDownloader(t.TempDir())
+	d.HttpConfig = config
+	start = time.Now()
+	_, err = d.Plan(ctx)
+	if err == nil || time.Since(start) > 2*time.Second {
+		t.Error("expected a cancelled plan", err, time.Since(start))
+	}
+}
+
+// testClock is a clock that is far in the future after the start
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func TestNewExponentialBackOff(t *testing.T) {
+	b := newExponentialBackOff()
+	clock := &testClock{now: time.Now()}
+	b.Clock = clock
+	b.Reset()
+	// an operation that failed after an hour is still retried
+	clock.now = clock.now.Add(time.Hour)
+	if b.NextBackOff() == backoff.Stop {
+		t.Error("expected a retry after an hour")
+	}
+}
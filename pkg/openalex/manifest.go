@@ -2,10 +2,10 @@ package openalex
 
 import (
 	"crypto/sha256"
-	"errors"
 	"fmt"
 	"log/slog"
-	"path"
+	"path/filepath"
+	"runtime"
 	"strings"
 )
 
@@ -20,8 +20,23 @@ const (
 	ManifestUrlPublishers   ManifestUrl = "https://openalex.s3.amazonaws.com/data/publishers/manifest"
 	ManifestUrlSources      ManifestUrl = "https://openalex.s3.amazonaws.com/data/sources/manifest"
 	ManifestUrlWorks        ManifestUrl = "https://openalex.s3.amazonaws.com/data/works/manifest"
+	ManifestUrlTopics       ManifestUrl = "https://openalex.s3.amazonaws.com/data/topics/manifest"
+	ManifestUrlDomains      ManifestUrl = "https://openalex.s3.amazonaws.com/data/domains/manifest"
 )
 
+// Key returns the object key of the manifest within the bucket, e.g. data/works/manifest.
+// Both the https and the s3 url of the OpenAlex bucket are supported.
+func (m ManifestUrl) Key() string {
+	key := strings.TrimPrefix(string(m), DefaultSnapshotBaseUrl)
+	key = strings.TrimPrefix(key, snapshotS3Prefix)
+	return strings.TrimPrefix(key, "/")
+}
+
+// LocalPath returns the path of the manifest in a local data directory, e.g. /openalex/data/works/manifest
+func (m ManifestUrl) LocalPath(dataDir string) string {
+	return filepath.Join(dataDir, filepath.FromSlash(strings.TrimPrefix(m.Key(), "data/")))
+}
+
 // AllManifestUrls is a list of all manifest URLs
 var AllManifestUrls = []ManifestUrl{
 	ManifestUrlAuthors,
@@ -31,23 +46,52 @@ var AllManifestUrls = []ManifestUrl{
 	ManifestUrlPublishers,
 	ManifestUrlSources,
 	ManifestUrlWorks,
+	ManifestUrlTopics,
+	ManifestUrlDomains,
 }
 
 // Manifest is a struct that represents the manifest file
 type Manifest struct {
-	Entries []struct {
-		URL  string `json:"url"`
-		Meta struct {
-			ContentLength int `json:"content_length"`
-			RecordCount   int `json:"record_count"`
-		} `json:"meta"`
-	} `json:"entries"`
-	Meta struct {
+	Entries []ManifestEntry `json:"entries"`
+	Meta    struct {
 		ContentLength int64 `json:"content_length"`
 		RecordCount   int   `json:"record_count"`
 	} `json:"meta"`
 }
 
+// ManifestEntry is a part file that is listed in a manifest
+type ManifestEntry struct {
+	URL  string            `json:"url"` // e.g. s3://openalex/data/works/updated_date=2023-05-16/part_000.gz
+	Meta ManifestEntryMeta `json:"meta"`
+}
+
+// ManifestEntryMeta contains the size and the number of records of a part file
+type ManifestEntryMeta struct {
+	ContentLength int `json:"content_length"`
+	RecordCount   int `json:"record_count"`
+}
+
+// Key returns the object key of the part file within the bucket, e.g. data/works/updated_date=2023-05-16/part_000.gz
+func (e *ManifestEntry) Key() string {
+	return s3UrlToKey(e.URL)
+}
+
+// EntityType returns the entity type of the part file
+func (e *ManifestEntry) EntityType() (FileEntityType, error) {
+	return GetEntityType(e.Key())
+}
+
+// UpdatedDate returns the date of the updated_date partition, e.g. 2023-05-16
+func (e *ManifestEntry) UpdatedDate() string {
+	return strings.TrimPrefix(getUpdatedDate(e.URL), "updated_date=")
+}
+
+// LocalPath returns the path of the part file in a local data directory,
+// i.e. the directory that contains the entity directories, e.g. /openalex/data
+func (e *ManifestEntry) LocalPath(dataDir string) string {
+	return filepath.Join(dataDir, filepath.FromSlash(strings.TrimPrefix(e.Key(), "data/")))
+}
+
 // Hash returns the SHA256 hash of the manifest
 func (m *Manifest) Hash() (result string, err error) {
 	data, err := json.Marshal(m)
@@ -59,37 +103,14 @@ func (m *Manifest) Hash() (result string, err error) {
 	return
 }
 
-// CompareData gets the amount of records in parsed data and compares it with the RecordCount in Manifest
+// CompareData counts the records of the part files and compares them with the RecordCount in Manifest.
+// The RootPath is the data directory that contains the entity directories, e.g. /openalex/data.
+// The state handler is not used anymore.
+// Use VerifyRecordCounts to get the counts of every file.
 func (m *Manifest) CompareData(RootPath string, sh *StateHandler) (err error) {
-	for _, entity := range m.Entries {
-		after, _ := strings.CutPrefix(entity.URL, "s3://openalex")
-		filePath := path.Join(RootPath, after)
-		slog.With("filePath", filePath)
-
-		manifestCount := entity.Meta.RecordCount
-		p := Processor{
-			DirectoryPath: RootPath,
-			LineHandler:   PrintLineHandler,
-		}
-		parsedCount, err := p.ParseFile(filePath)
-		if err != nil {
-			slog.With("error", err).Error("Failed to parse gz-file from loaded manifest")
-			return err
-		}
-
-		result := manifestCount - parsedCount
-		switch {
-		case result > 0:
-			errMissed := errors.New("not matched, data missing")
-			slog.With("err", errMissed).Error("Not matched, data missing")
-			return errMissed
-		case result < 0:
-			errOutdated := errors.New("not matched, data outdated")
-			slog.With("err", errOutdated).Error("Not matched, data missing")
-			return errOutdated
-		default:
-			slog.Info("Data matched")
-		}
+	report, err := m.VerifyRecordCounts(RootPath, runtime.NumCPU())
+	if err != nil {
+		return err
 	}
-	return nil
+	return report.Err()
 }
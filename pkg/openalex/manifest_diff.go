@@ -0,0 +1,215 @@
+package openalex
+
+import (
+	"sort"
+)
+
+// PartitionSummary sums up the part files of an updated_date partition of a manifest
+type PartitionSummary struct {
+	UpdatedDate string `json:"updated_date"`
+	Files       int    `json:"files"`
+	Bytes       int64  `json:"bytes"`
+	Records     int    `json:"records"`
+}
+
+// PartitionChange is a partition that is listed in both manifests with different part files
+type PartitionChange struct {
+	UpdatedDate string           `json:"updated_date"`
+	Old         PartitionSummary `json:"old"`
+	New         PartitionSummary `json:"new"`
+}
+
+// DateRange is an inclusive range of dates, both dates are empty if the range is empty
+type DateRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// extend extends the range by the date
+func (r *DateRange) extend(date string) {
+	if r.From == "" || date < r.From {
+		r.From = date
+	}
+	if r.To == "" || date > r.To {
+		r.To = date
+	}
+}
+
+// EntityManifestDiff contains the changes of the manifest of an entity type
+type EntityManifestDiff struct {
+	EntityType  FileEntityType     `json:"entity_type"`
+	Added       []PartitionSummary `json:"added"`   // partitions that are only listed in the new manifest
+	Removed     []PartitionSummary `json:"removed"` // partitions that are only listed in the old manifest
+	Resized     []PartitionChange  `json:"resized"` // partitions with different files, bytes or records
+	RecordDelta int                `json:"record_delta"`
+	ByteDelta   int64              `json:"byte_delta"`
+	// UpdatedDates is the range of the partitions of the new manifest
+	UpdatedDates DateRange `json:"updated_dates"`
+	// ChangedUpdatedDates is the range of the added and resized partitions,
+	// i.e. the partitions that need to be processed again
+	ChangedUpdatedDates DateRange `json:"changed_updated_dates"`
+}
+
+// IsEmpty returns true if the manifests of the entity type list the same partitions
+func (e *EntityManifestDiff) IsEmpty() bool {
+	return len(e.Added) == 0 && len(e.Removed) == 0 && len(e.Resized) == 0
+}
+
+// ManifestDiff contains the changes between two manifests or two snapshots
+type ManifestDiff struct {
+	Entities    []*EntityManifestDiff `json:"entities"` // in the order of AllManifestUrls
+	RecordDelta int                   `json:"record_delta"`
+	ByteDelta   int64                 `json:"byte_delta"`
+}
+
+// IsEmpty returns true if no partition was added, removed or resized
+func (d *ManifestDiff) IsEmpty() bool {
+	for _, e := range d.Entities {
+		if !e.IsEmpty() {
+			return false
+		}
+	}
+	return true
+}
+
+// partitionKey identifies a partition of a manifest
+type partitionKey struct {
+	entityType  FileEntityType
+	updatedDate string
+}
+
+// summarizePartitions groups the entries of a manifest by their partition
+func summarizePartitions(m *Manifest) (partitions map[partitionKey]*PartitionSummary, files map[partitionKey]map[string]ManifestEntryMeta, err error) {
+	partitions = make(map[partitionKey]*PartitionSummary)
+	files = make(map[partitionKey]map[string]ManifestEntryMeta)
+	if m == nil {
+		return partitions, files, nil
+	}
+	for _, entry := range m.Entries {
+		entityType, errType := entry.EntityType()
+		if errType != nil {
+			return nil, nil, errType
+		}
+		key := partitionKey{entityType: entityType, updatedDate: entry.UpdatedDate()}
+		summary, ok := partitions[key]
+		if !ok {
+			summary = &PartitionSummary{UpdatedDate: key.updatedDate}
+			partitions[key] = summary
+			files[key] = make(map[string]ManifestEntryMeta)
+		}
+		summary.Files++
+		summary.Bytes += int64(entry.Meta.ContentLength)
+		summary.Records += entry.Meta.RecordCount
+		files[key][entry.Key()] = entry.Meta
+	}
+	return partitions, files, nil
+}
+
+// sameFiles returns true if both partitions contain the same files with the same sizes and record counts
+func sameFiles(a, b map[string]ManifestEntryMeta) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, meta := range a {
+		if other, ok := b[key]; !ok || other != meta {
+			return false
+		}
+	}
+	return true
+}
+
+// DiffManifests compares two manifests partition by partition.
+// The manifests may contain the entries of several entity types, a nil manifest is treated as empty.
+func DiffManifests(oldManifest, newManifest *Manifest) (diff *ManifestDiff, err error) {
+	oldPartitions, oldFiles, err := summarizePartitions(oldManifest)
+	if err != nil {
+		return nil, err
+	}
+	newPartitions, newFiles, err := summarizePartitions(newManifest)
+	if err != nil {
+		return nil, err
+	}
+
+	entities := make(map[FileEntityType]*EntityManifestDiff)
+	entity := func(entityType FileEntityType) *EntityManifestDiff {
+		e, ok := entities[entityType]
+		if !ok {
+			e = &EntityManifestDiff{EntityType: entityType}
+			entities[entityType] = e
+		}
+		return e
+	}
+	for key, newSummary := range newPartitions {
+		e := entity(key.entityType)
+		e.UpdatedDates.extend(key.updatedDate)
+		e.RecordDelta += newSummary.Records
+		e.ByteDelta += newSummary.Bytes
+		oldSummary, ok := oldPartitions[key]
+		if !ok {
+			e.Added = append(e.Added, *newSummary)
+			e.ChangedUpdatedDates.extend(key.updatedDate)
+			continue
+		}
+		if !sameFiles(oldFiles[key], newFiles[key]) {
+			e.Resized = append(e.Resized, PartitionChange{UpdatedDate: key.updatedDate, Old: *oldSummary, New: *newSummary})
+			e.ChangedUpdatedDates.extend(key.updatedDate)
+		}
+	}
+	for key, oldSummary := range oldPartitions {
+		e := entity(key.entityType)
+		e.RecordDelta -= oldSummary.Records
+		e.ByteDelta -= oldSummary.Bytes
+		if _, ok := newPartitions[key]; !ok {
+			e.Removed = append(e.Removed, *oldSummary)
+		}
+	}
+
+	// order the entity types and partitions
+	diff = &ManifestDiff{}
+	for _, manifestUrl := range AllManifestUrls {
+		entityType, errType := GetEntityType(manifestUrl.Key())
+		if errType != nil {
+			return nil, errType
+		}
+		e, ok := entities[entityType]
+		if !ok {
+			continue
+		}
+		sort.Slice(e.Added, func(i, j int) bool { return e.Added[i].UpdatedDate < e.Added[j].UpdatedDate })
+		sort.Slice(e.Removed, func(i, j int) bool { return e.Removed[i].UpdatedDate < e.Removed[j].UpdatedDate })
+		sort.Slice(e.Resized, func(i, j int) bool { return e.Resized[i].UpdatedDate < e.Resized[j].UpdatedDate })
+		diff.Entities = append(diff.Entities, e)
+		diff.RecordDelta += e.RecordDelta
+		diff.ByteDelta += e.ByteDelta
+	}
+	return diff, nil
+}
+
+// DiffSnapshotDirectories compares the local manifests of two data directories,
+// i.e. the directories that contain the entity directories, e.g. /openalex-2024-01/data
+func DiffSnapshotDirectories(oldDataDir, newDataDir string) (diff *ManifestDiff, err error) {
+	oldManifest, err := combineManifests(oldDataDir)
+	if err != nil {
+		return nil, err
+	}
+	newManifest, err := combineManifests(newDataDir)
+	if err != nil {
+		return nil, err
+	}
+	return DiffManifests(oldManifest, newManifest)
+}
+
+// combineManifests reads the manifests of a data directory into a single manifest
+func combineManifests(dataDir string) (combined *Manifest, err error) {
+	manifests, err := ReadManifestsFromDirectory(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	combined = &Manifest{}
+	for _, manifest := range manifests {
+		combined.Entries = append(combined.Entries, manifest.Entries...)
+		combined.Meta.ContentLength += manifest.Meta.ContentLength
+		combined.Meta.RecordCount += manifest.Meta.RecordCount
+	}
+	return combined, nil
+}
@@ -0,0 +1,85 @@
+package openalex
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffManifests(t *testing.T) {
+	var oldManifest, newManifest Manifest
+	err := json.Unmarshal([]byte(`{"entries": [
+		{"url": "s3://openalex/data/works/updated_date=2023-05-01/part_000.gz", "meta": {"content_length": 100, "record_count": 10}},
+		{"url": "s3://openalex/data/works/updated_date=2023-05-02/part_000.gz", "meta": {"content_length": 100, "record_count": 10}},
+		{"url": "s3://openalex/data/works/updated_date=2023-05-03/part_000.gz", "meta": {"content_length": 100, "record_count": 10}},
+		{"url": "s3://openalex/data/authors/updated_date=2023-05-01/part_000.gz", "meta": {"content_length": 50, "record_count": 5}}
+	]}`), &oldManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal([]byte(`{"entries": [
+		{"url": "s3://openalex/data/works/updated_date=2023-05-02/part_000.gz", "meta": {"content_length": 100, "record_count": 10}},
+		{"url": "s3://openalex/data/works/updated_date=2023-05-03/part_000.gz", "meta": {"content_length": 150, "record_count": 12}},
+		{"url": "s3://openalex/data/works/updated_date=2023-06-01/part_000.gz", "meta": {"content_length": 100, "record_count": 20}},
+		{"url": "s3://openalex/data/works/updated_date=2023-06-01/part_001.gz", "meta": {"content_length": 100, "record_count": 20}},
+		{"url": "s3://openalex/data/authors/updated_date=2023-05-01/part_000.gz", "meta": {"content_length": 50, "record_count": 5}}
+	]}`), &newManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := DiffManifests(&oldManifest, &newManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Entities) != 2 || diff.IsEmpty() {
+		t.Fatal("unexpected diff", diff.Entities)
+	}
+	authors, works := diff.Entities[0], diff.Entities[1]
+	if !authors.IsEmpty() || authors.RecordDelta != 0 {
+		t.Error("authors did not change", authors)
+	}
+	if len(works.Added) != 1 || works.Added[0].UpdatedDate != "2023-06-01" || works.Added[0].Files != 2 || works.Added[0].Records != 40 {
+		t.Error("unexpected added partitions", works.Added)
+	}
+	if len(works.Removed) != 1 || works.Removed[0].UpdatedDate != "2023-05-01" {
+		t.Error("unexpected removed partitions", works.Removed)
+	}
+	if len(works.Resized) != 1 || works.Resized[0].Old.Bytes != 100 || works.Resized[0].New.Bytes != 150 {
+		t.Error("unexpected resized partitions", works.Resized)
+	}
+	if works.RecordDelta != 32 || works.ByteDelta != 150 || diff.RecordDelta != 32 {
+		t.Error("unexpected deltas", works.RecordDelta, works.ByteDelta, diff.RecordDelta)
+	}
+	if works.UpdatedDates != (DateRange{From: "2023-05-02", To: "2023-06-01"}) || works.ChangedUpdatedDates != (DateRange{From: "2023-05-03", To: "2023-06-01"}) {
+		t.Error("unexpected ranges", works.UpdatedDates, works.ChangedUpdatedDates)
+	}
+	data, err := json.Marshal(diff)
+	if err != nil || !strings.Contains(string(data), `"record_delta":32`) {
+		t.Error("unexpected json", string(data), err)
+	}
+
+	// snapshot directories
+	oldDir, newDir := t.TempDir(), t.TempDir()
+	for dir, manifest := range map[string]*Manifest{oldDir: &oldManifest, newDir: &newManifest} {
+		data, err = json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.MkdirAll(filepath.Join(dir, "works"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(dir, "works", "manifest"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	diff, err = DiffSnapshotDirectories(oldDir, newDir)
+	if err != nil || diff.RecordDelta != 32 {
+		t.Error("unexpected directory diff", diff, err)
+	}
+	diff, err = DiffManifests(&newManifest, &newManifest)
+	if err != nil || !diff.IsEmpty() {
+		t.Error("expected an empty diff", diff, err)
+	}
+}
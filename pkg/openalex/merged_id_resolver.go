@@ -0,0 +1,222 @@
+package openalex
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrMergeCycle is returned when the merged ids contain a cycle, e.g. A→B→A
+var ErrMergeCycle = errors.New("merged ids contain a cycle")
+
+// MergedIDResolver resolves merged ids to the ids they were merged into.
+// It follows chains of merges (A→B→C) and detects cycles.
+type MergedIDResolver struct {
+	mu     sync.RWMutex
+	merges map[string]MergedID // by id
+}
+
+// NewMergedIDResolver creates an empty resolver
+func NewMergedIDResolver() *MergedIDResolver {
+	return &MergedIDResolver{merges: make(map[string]MergedID)}
+}
+
+// NewMergedIDResolverFromDirectory reads all merged_ids/<entity>/*.csv(.gz) files of a data directory,
+// i.e. the directory that contains the entity directories, e.g. /openalex/data
+func NewMergedIDResolverFromDirectory(dataDir string) (r *MergedIDResolver, err error) {
+	logger := slog.With("dataDir", dataDir)
+	r = NewMergedIDResolver()
+	err = filepath.Walk(filepath.Join(dataDir, "merged_ids"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !(strings.HasSuffix(path, ".csv") || strings.HasSuffix(path, ".csv.gz")) {
+			return nil
+		}
+		_, errParse := ParseMergedIDsFile(path, r.Handler())
+		return errParse
+	})
+	if err != nil {
+		logger.With("err", err).Error("error while reading the merged ids files")
+		return nil, err
+	}
+	logger.With("mergedIds", r.Len()).Info("Finished reading the merged ids files")
+	return r, nil
+}
+
+// Add adds a merge.
+// If an id was merged several times, the merge with the latest merge date is kept.
+func (r *MergedIDResolver) Add(mergedID MergedID) {
+	mergedID.ID = normalizeOpenAlexID(mergedID.ID)
+	mergedID.MergeIntoID = normalizeOpenAlexID(mergedID.MergeIntoID)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.merges[mergedID.ID]; ok && existing.MergeDate.After(mergedID.MergeDate.Time) {
+		return
+	}
+	r.merges[mergedID.ID] = mergedID
+}
+
+// Handler returns a MergedIdRecordHandler that adds the merges to the resolver,
+// e.g. for Processor.MergedIdHandler or ParseMergedIDsFile
+func (r *MergedIDResolver) Handler() MergedIdRecordHandler {
+	return func(fileEntityType FileEntityType, mergedID MergedID) error {
+		r.Add(mergedID)
+		return nil
+	}
+}
+
+// Len returns the number of merged ids
+func (r *MergedIDResolver) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.merges)
+}
+
+// Resolve returns the id the id was finally merged into and the chain of merges, starting with the id.
+// The id can be a short id (e.g. A3125154712) or an OpenAlex url, the result is a short id.
+// ok is false if the id was not merged, then the id itself is returned,
+// or if the merges contain a cycle, then the canonical id is empty and the chain ends with the repeated id.
+func (r *MergedIDResolver) Resolve(id string) (canonicalID string, chain []string, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	id = normalizeOpenAlexID(id)
+	if _, merged := r.merges[id]; !merged {
+		return id, nil, false
+	}
+	visited := make(map[string]struct{})
+	current := id
+	for {
+		chain = append(chain, current)
+		if _, seen := visited[current]; seen {
+			return "", chain, false
+		}
+		visited[current] = struct{}{}
+		merge, merged := r.merges[current]
+		if !merged {
+			return current, chain, true
+		}
+		current = merge.MergeIntoID
+	}
+}
+
+// Cycles returns the cycles of the merges, each cycle starts with its smallest id
+func (r *MergedIDResolver) Cycles() (cycles [][]string) {
+	r.mu.RLock()
+	ids := make([]string, 0, len(r.merges))
+	for id := range r.merges {
+		ids = append(ids, id)
+	}
+	r.mu.RUnlock()
+	sort.Strings(ids)
+
+	found := make(map[string]struct{})
+	for _, id := range ids {
+		canonicalID, chain, _ := r.Resolve(id)
+		if canonicalID != "" {
+			continue
+		}
+		// the cycle starts at the first occurrence of the repeated id
+		repeated := chain[len(chain)-1]
+		start := 0
+		for chain[start] != repeated {
+			start++
+		}
+		cycle := chain[start : len(chain)-1]
+		smallest := 0
+		for i := range cycle {
+			if cycle[i] < cycle[smallest] {
+				smallest = i
+			}
+		}
+		cycle = append(append([]string{}, cycle[smallest:]...), cycle[:smallest]...)
+		if _, ok := found[cycle[0]]; ok {
+			continue
+		}
+		found[cycle[0]] = struct{}{}
+		cycles = append(cycles, cycle)
+	}
+	return cycles
+}
+
+// Validate returns ErrMergeCycle if the merges contain a cycle
+func (r *MergedIDResolver) Validate() error {
+	cycles := r.Cycles()
+	if len(cycles) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %d cycles, e.g. %s", ErrMergeCycle, len(cycles), strings.Join(cycles[0], "→"))
+}
+
+// mergedIDResolverFile is the format of a persisted resolver
+type mergedIDResolverFile struct {
+	MergedIds []MergedID `json:"merged_ids"`
+}
+
+// WriteToFile persists the merges as gzipped json, so they can be read again without the csv files
+func (r *MergedIDResolver) WriteToFile(filePath string) (err error) {
+	logger := slog.With("filePath", filePath)
+	r.mu.RLock()
+	data := mergedIDResolverFile{MergedIds: make([]MergedID, 0, len(r.merges))}
+	for _, merge := range r.merges {
+		data.MergedIds = append(data.MergedIds, merge)
+	}
+	r.mu.RUnlock()
+	sort.Slice(data.MergedIds, func(i, j int) bool { return data.MergedIds[i].ID < data.MergedIds[j].ID })
+
+	// write to a temporary file, so that an existing file is only replaced by a complete one
+	tmpPath := filePath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		logger.With("err", err).Error("error creating file")
+		return err
+	}
+	writer := gzip.NewWriter(file)
+	err = json.NewEncoder(writer).Encode(data)
+	if errClose := writer.Close(); err == nil {
+		err = errClose
+	}
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		logger.With("err", err).Error("error writing merged ids")
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
+
+// ReadMergedIDResolverFromFile reads a resolver that was persisted with WriteToFile
+func ReadMergedIDResolverFromFile(filePath string) (r *MergedIDResolver, err error) {
+	logger := slog.With("filePath", filePath)
+	file, err := os.Open(filePath)
+	if err != nil {
+		logger.With("err", err).Error("error opening file")
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		logger.With("err", err).Error("error opening gz file")
+		return nil, err
+	}
+	defer reader.Close()
+	var data mergedIDResolverFile
+	err = json.NewDecoder(reader).Decode(&data)
+	if err != nil {
+		logger.With("err", err).Error("error reading merged ids")
+		return nil, err
+	}
+	r = &MergedIDResolver{merges: make(map[string]MergedID, len(data.MergedIds))}
+	for _, merge := range data.MergedIds {
+		r.merges[merge.ID] = merge
+	}
+	return r, nil
+}
@@ -0,0 +1,71 @@
+package openalex
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergedIDResolver(t *testing.T) {
+	r := NewMergedIDResolver()
+	r.Add(MergedID{MergeDate: testMergeDate(t, "2023-01-01"), ID: "W1", MergeIntoID: "W2"})
+	r.Add(MergedID{MergeDate: testMergeDate(t, "2023-02-01"), ID: "W2", MergeIntoID: "W3"})
+	r.Add(MergedID{MergeDate: testMergeDate(t, "2023-01-01"), ID: "W4", MergeIntoID: "W9"})
+	r.Add(MergedID{MergeDate: testMergeDate(t, "2023-03-01"), ID: "W4", MergeIntoID: "W5"})
+	r.Add(MergedID{MergeDate: testMergeDate(t, "2023-02-01"), ID: "W4", MergeIntoID: "W8"}) // older than the kept merge
+	r.Add(MergedID{MergeDate: testMergeDate(t, "2023-01-01"), ID: "W6", MergeIntoID: "W7"})
+	r.Add(MergedID{MergeDate: testMergeDate(t, "2023-01-01"), ID: "W7", MergeIntoID: "W6"})
+
+	canonicalID, chain, ok := r.Resolve("https://openalex.org/W1")
+	if !ok || canonicalID != "W3" || strings.Join(chain, ",") != "W1,W2,W3" {
+		t.Error("unexpected resolution", canonicalID, chain, ok)
+	}
+	canonicalID, _, ok = r.Resolve("W4")
+	if !ok || canonicalID != "W5" {
+		t.Error("the latest merge must be kept", canonicalID)
+	}
+	canonicalID, chain, ok = r.Resolve("W3")
+	if ok || canonicalID != "W3" || chain != nil {
+		t.Error("W3 was not merged", canonicalID, chain, ok)
+	}
+	canonicalID, chain, ok = r.Resolve("W6")
+	if ok || canonicalID != "" || strings.Join(chain, ",") != "W6,W7,W6" {
+		t.Error("expected a cycle", canonicalID, chain, ok)
+	}
+	cycles := r.Cycles()
+	if len(cycles) != 1 || strings.Join(cycles[0], ",") != "W6,W7" {
+		t.Error("unexpected cycles", cycles)
+	}
+	if !errors.Is(r.Validate(), ErrMergeCycle) {
+		t.Error("expected a cycle error")
+	}
+
+	// persist and read again
+	filePath := filepath.Join(t.TempDir(), "merged_ids.json.gz")
+	if err := r.WriteToFile(filePath); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadMergedIDResolverFromFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonicalID, chain, ok = loaded.Resolve("W1")
+	if loaded.Len() != r.Len() || !ok || canonicalID != "W3" || len(chain) != 3 {
+		t.Error("unexpected loaded resolver", loaded.Len(), canonicalID, chain, ok)
+	}
+}
+
+func TestNewMergedIDResolverFromDirectory(t *testing.T) {
+	r, err := NewMergedIDResolverFromDirectory("../../sample/openalex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonicalID, _, ok := r.Resolve("A3125154712")
+	if !ok || canonicalID != "A4317838346" {
+		t.Error("unexpected resolution", canonicalID, ok)
+	}
+	if err = r.Validate(); err != nil {
+		t.Error(err)
+	}
+}
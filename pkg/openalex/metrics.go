@@ -0,0 +1,270 @@
+package openalex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MetricSample is a sample of a metric in the Prometheus text format
+type MetricSample struct {
+	Name   string // e.g. openalex_lines_processed_total
+	Help   string
+	Type   string // counter or gauge
+	Labels map[string]string
+	Value  float64
+}
+
+// MetricsCollector returns samples of additional metrics, it is called on every scrape
+type MetricsCollector func() []MetricSample
+
+// Metrics collects the metrics of Processor runs and exposes them in the Prometheus text format.
+// It is safe for concurrent use and can be shared by several processors.
+type Metrics struct {
+	mu         sync.Mutex
+	entities   map[FileEntityType]*entityMetrics
+	collectors []MetricsCollector
+}
+
+// entityMetrics are the metrics of an entity type
+type entityMetrics struct {
+	lines         atomic.Int64
+	bytes         atomic.Int64
+	handlerErrors atomic.Int64
+	files         atomic.Int64
+	inProcess     int            // files in process, guarded by Metrics.mu
+	partitions    map[string]int // files in process by partition, guarded by Metrics.mu
+}
+
+// NewMetrics creates empty metrics
+func NewMetrics() *Metrics {
+	return &Metrics{entities: make(map[FileEntityType]*entityMetrics)}
+}
+
+// AddCollector adds metrics that are collected on every scrape, e.g. the stats of a bulk indexer
+func (m *Metrics) AddCollector(collector MetricsCollector) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collectors = append(m.collectors, collector)
+}
+
+// startFile marks a file as in process and returns the metrics of its entity type
+func (m *Metrics) startFile(entityType FileEntityType, filePath string) *entityMetrics {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entities == nil {
+		m.entities = make(map[FileEntityType]*entityMetrics)
+	}
+	em, ok := m.entities[entityType]
+	if !ok {
+		em = &entityMetrics{partitions: make(map[string]int)}
+		m.entities[entityType] = em
+	}
+	em.inProcess++
+	em.partitions[metricsPartition(filePath)]++
+	return em
+}
+
+// finishFile removes a file from the files in process and counts it
+func (m *Metrics) finishFile(entityType FileEntityType, filePath string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if em, ok := m.entities[entityType]; ok {
+		em.inProcess--
+		partition := metricsPartition(filePath)
+		em.partitions[partition]--
+		if em.partitions[partition] <= 0 {
+			delete(em.partitions, partition)
+		}
+		em.files.Add(1)
+	}
+}
+
+// metricsPartition returns the partition of a file, e.g. 2023-05-01.
+// The file path itself is not used as a label, so that the number of series stays small.
+func metricsPartition(filePath string) string {
+	return strings.TrimPrefix(getUpdatedDate(filePath), "updated_date=")
+}
+
+// addRead counts a read line
+func (em *entityMetrics) addRead(bytes int64) {
+	if em != nil {
+		em.bytes.Add(bytes)
+	}
+}
+
+// addLines counts handled lines
+func (em *entityMetrics) addLines(lines int64) {
+	if em != nil {
+		em.lines.Add(lines)
+	}
+}
+
+// addHandlerError counts a failed line
+func (em *entityMetrics) addHandlerError() {
+	if em != nil {
+		em.handlerErrors.Add(1)
+	}
+}
+
+// Samples returns the samples of all metrics, including the ones of the collectors
+func (m *Metrics) Samples() (samples []MetricSample) {
+	m.mu.Lock()
+	entityTypes := make([]FileEntityType, 0, len(m.entities))
+	for entityType := range m.entities {
+		entityTypes = append(entityTypes, entityType)
+	}
+	sort.Slice(entityTypes, func(i, j int) bool { return entityTypes[i] < entityTypes[j] })
+	var current []MetricSample
+	for _, entityType := range entityTypes {
+		em := m.entities[entityType]
+		labels := map[string]string{"entity_type": string(entityType)}
+		samples = append(samples,
+			MetricSample{Name: "openalex_lines_processed_total", Help: "Lines passed to the line handler.", Type: "counter", Labels: labels, Value: float64(em.lines.Load())},
+			MetricSample{Name: "openalex_bytes_read_total", Help: "Uncompressed bytes read from the files.", Type: "counter", Labels: labels, Value: float64(em.bytes.Load())},
+			MetricSample{Name: "openalex_handler_errors_total", Help: "Lines that failed in the line handler.", Type: "counter", Labels: labels, Value: float64(em.handlerErrors.Load())},
+			MetricSample{Name: "openalex_files_processed_total", Help: "Files that were processed.", Type: "counter", Labels: labels, Value: float64(em.files.Load())},
+			MetricSample{Name: "openalex_files_in_process", Help: "Files that are in process.", Type: "gauge", Labels: labels, Value: float64(em.inProcess)},
+		)
+		partitions := make([]string, 0, len(em.partitions))
+		for partition := range em.partitions {
+			partitions = append(partitions, partition)
+		}
+		sort.Strings(partitions)
+		for _, partition := range partitions {
+			current = append(current, MetricSample{
+				Name:   "openalex_current_partition",
+				Help:   "Files in process by partition.",
+				Type:   "gauge",
+				Labels: map[string]string{"entity_type": string(entityType), "partition": partition},
+				Value:  float64(em.partitions[partition]),
+			})
+		}
+	}
+	collectors := append([]MetricsCollector(nil), m.collectors...)
+	m.mu.Unlock()
+
+	samples = append(samples, current...)
+	for _, collector := range collectors {
+		samples = append(samples, collector()...)
+	}
+	return samples
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format.
+// The samples are grouped by name, the help and type of the first sample of a name are used.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	samples := m.Samples()
+	var names []string
+	byName := make(map[string][]MetricSample)
+	for _, sample := range samples {
+		if _, ok := byName[sample.Name]; !ok {
+			names = append(names, sample.Name)
+		}
+		byName[sample.Name] = append(byName[sample.Name], sample)
+	}
+	var b strings.Builder
+	for _, name := range names {
+		family := byName[name]
+		if family[0].Help != "" {
+			fmt.Fprintf(&b, "# HELP %s %s\n", name, escapeMetricHelp(family[0].Help))
+		}
+		if family[0].Type != "" {
+			fmt.Fprintf(&b, "# TYPE %s %s\n", name, family[0].Type)
+		}
+		for _, sample := range family {
+			b.WriteString(name)
+			writeMetricLabels(&b, sample.Labels)
+			b.WriteByte(' ')
+			b.WriteString(strconv.FormatFloat(sample.Value, 'g', -1, 64))
+			b.WriteByte('\n')
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeMetricLabels writes the labels sorted by name
+func writeMetricLabels(b *strings.Builder, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	b.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(key)
+		b.WriteString(`="`)
+		b.WriteString(escapeMetricLabel(labels[key]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+}
+
+var metricLabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var metricHelpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeMetricLabel(value string) string {
+	return metricLabelReplacer.Replace(value)
+}
+
+func escapeMetricHelp(value string) string {
+	return metricHelpReplacer.Replace(value)
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	err := m.WritePrometheus(w)
+	if err != nil {
+		slog.With("err", err).Error("error writing metrics")
+	}
+}
+
+// ListenAndServe serves the metrics on /metrics of the address, e.g. localhost:9090,
+// until the context is cancelled
+func (m *Metrics) ListenAndServe(ctx context.Context, addr string) error {
+	logger := slog.With("addr", addr)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = server.Shutdown(shutdownCtx)
+		}
+	}()
+	logger.Info("Serving metrics")
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.With("err", err).Error("error serving metrics")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package openalex
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessorMetrics(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "works", "updated_date=2023-05-01", "part_000.gz")
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, gzipBytes(t, `{"id":"W1"}`, `bad`, `{"id":"W2"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := NewMetrics()
+	var current string
+	p := Processor{
+		DirectoryPath: dir,
+		ErrorPolicy:   ErrorSkipAndRecord,
+		Metrics:       metrics,
+		LineHandler: func(filePath string, line string) error {
+			if current == "" {
+				var b strings.Builder
+				if err := metrics.WritePrometheus(&b); err != nil {
+					t.Error(err)
+				}
+				current = b.String()
+			}
+			if line == "bad" {
+				return errors.New("bad line")
+			}
+			return nil
+		},
+	}
+	metrics.AddCollector(func() []MetricSample {
+		return []MetricSample{{Name: "test_bulk_indexer_added_total", Type: "counter", Labels: map[string]string{"entity_type": `wo"rks`}, Value: 7}}
+	})
+	if _, err := p.ProcessDirectory(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(current, `openalex_current_partition{entity_type="works",partition="2023-05-01"} 1`) {
+		t.Error("expected the current partition", current)
+	}
+	if strings.Contains(current, "file_path") {
+		t.Error("expected no file path label", current)
+	}
+
+	server := httptest.NewServer(metrics)
+	defer server.Close()
+	res, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"# TYPE openalex_lines_processed_total counter\n",
+		`openalex_lines_processed_total{entity_type="works"} 2`,
+		`openalex_bytes_read_total{entity_type="works"} 28`,
+		`openalex_handler_errors_total{entity_type="works"} 1`,
+		`openalex_files_processed_total{entity_type="works"} 1`,
+		`openalex_files_in_process{entity_type="works"} 0`,
+		`test_bulk_indexer_added_total{entity_type="wo\"rks"} 7`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Error("missing", expected, string(body))
+		}
+	}
+	if strings.Contains(string(body), "openalex_current_partition") {
+		t.Error("expected no current partition after the run", string(body))
+	}
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Error("unexpected content type", res.Header.Get("Content-Type"))
+	}
+}
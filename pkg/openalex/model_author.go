@@ -74,5 +74,5 @@ func (a *Author) GetID() string {
 
 // GetType returns the entity type
 func (a *Author) GetType() string {
-	return string(WorksFileEntityType)
+	return string(AuthorsFileEntityType)
 }
@@ -67,5 +67,5 @@ func (c *Concept) GetID() string {
 
 // GetType returns the entity type
 func (c *Concept) GetType() string {
-	return string(WorksFileEntityType)
+	return string(ConceptsFileEntityType)
 }
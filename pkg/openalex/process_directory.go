@@ -1,27 +1,69 @@
 package openalex
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Processor struct {
-	DirectoryPath   string
+	DirectoryPath string
+	// StateHandler is optional and stores the finished files and lines of all entry points,
+	// including ParseFile and ProcessFiles. Files that are marked as finished are skipped,
+	// so a directory is only processed again with a new state database.
 	StateHandler    *StateHandler
 	LineHandler     LineHandler
 	MergedIdHandler MergedIdRecordHandler
+	// UseManifests takes the part files from the local manifests instead of walking the directory.
+	// Files that are not listed in any manifest are ignored.
+	UseManifests bool
+	// MissingFilePolicy defines how files that are listed in a manifest but do not exist are handled
+	MissingFilePolicy MissingFilePolicy
+	// IDRewriter is optional and rewrites references to merged entities before the lines are handled.
+	// The merged ids are processed last, so its resolver needs to be loaded before, e.g. with NewMergedIDResolverFromDirectory.
+	IDRewriter *IDRewriter
+	// MergedIdsSince only passes the merges after this date to the MergedIdHandler,
+	// e.g. the date of the last processed snapshot. The zero date passes all merges.
+	MergedIdsSince MergeDate
+	// Workers is the number of part files that are processed concurrently, 1 if not set.
+	// The LineHandler needs to be safe for concurrent use if it is greater than 1.
+	// The files are processed one by one if a StateHandler is set.
+	Workers int
+	// ContinueOnFileError processes the remaining files if a file fails.
+	// By default, no further files are started after the first error.
+	ContinueOnFileError bool
+	// Filter selects the files that are processed, before any file is opened.
+	// The zero value processes all files.
+	Filter FileFilter
+	// ErrorPolicy defines how lines are handled that fail in the IDRewriter or LineHandler, by default the file fails
+	ErrorPolicy ErrorPolicy
+	// MaxErrorsPerFile is the number of skipped lines after which a file fails with ErrorMaxPerFile
+	MaxErrorsPerFile int
+	// DeadLetters is optional and records the skipped lines
+	DeadLetters *DeadLetterWriter
+	// ProgressHandler is optional and receives the progress after every file and every ProgressInterval.
+	// The ETA is based on the record counts of the local manifests.
+	ProgressHandler ProgressHandler
+	// ProgressInterval is the interval of the periodic progress events, DefaultProgressInterval if not set
+	ProgressInterval time.Duration
+	// Metrics is optional and collects the lines, bytes, errors and current files per entity type,
+	// e.g. for a Prometheus endpoint with Metrics.ListenAndServe
+	Metrics *Metrics
 }
 
 // visit walks over files in a directory
 func visit(files *[]string) filepath.WalkFunc {
 	return func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			log.Fatal("visit", err)
+			return err
 		}
 		// do not include directories
 		// only include files with .gz extension
@@ -55,17 +97,27 @@ func containsMergedIDs(filePath string) bool {
 }
 
 // ProcessDirectory parses the directory of separated files and processes them
-func (p *Processor) ProcessDirectory() (err error) {
+func (p *Processor) ProcessDirectory() (report *RunReport, err error) {
+	return p.ProcessDirectoryContext(context.Background())
+}
+
+// ProcessDirectoryContext is ProcessDirectory with a context.
+// If the context is cancelled, the processing stops at the next line boundary
+// and an error that wraps the error of the context, e.g. context.Canceled, is returned.
+func (p *Processor) ProcessDirectoryContext(ctx context.Context) (report *RunReport, err error) {
 	logger := slog.With("directoryPath", p.DirectoryPath)
 	logger.Info("Start reading directory")
+	// the report is never nil, even if the files can not be listed
+	report = &RunReport{StartedAt: time.Now()}
 	// get the files
 	filePaths, err := p.GetFiles()
 	if err != nil {
 		logger.With("err", err).Error("error while reading the directory")
+		report.finish()
 		return
 	}
 	// process the files
-	err = p.ProcessFiles(filePaths)
+	report, err = p.ProcessFilesContext(ctx, filePaths)
 	if err != nil {
 		logger.With("err", err).Error("error while processing the files")
 		return
@@ -74,10 +126,25 @@ func (p *Processor) ProcessDirectory() (err error) {
 	return
 }
 
-// GetFiles returns a list of files in a directory
+// GetFiles returns a list of files in a directory.
+// If UseManifests is set, the files are taken from the local manifests.
 func (p *Processor) GetFiles() (filePaths []string, err error) {
 	logger := slog.With("directoryPath", p.DirectoryPath)
 	logger.Info("Start listing directory")
+	err = p.Filter.Validate()
+	if err != nil {
+		logger.With("err", err).Error("invalid file filter")
+		return nil, err
+	}
+	if p.UseManifests {
+		filePaths, err = p.getManifestFiles()
+		if err != nil {
+			return nil, err
+		}
+		filePaths = p.Filter.filterFiles(p.DirectoryPath, filePaths)
+		logger.With("files", len(filePaths)).Info("Finished listing directory")
+		return
+	}
 	// walk over the files in the directory
 	err = filepath.Walk(p.DirectoryPath, visit(&filePaths))
 	if err != nil {
@@ -85,48 +152,200 @@ func (p *Processor) GetFiles() (filePaths []string, err error) {
 		return
 	}
 
-	// order the files
+	// filter and order the files
+	filePaths = p.Filter.filterFiles(p.DirectoryPath, filePaths)
 	filePaths = OrderByMergedIDsLast(filePaths)
-	logger.Info("Finished listing directory")
+	logger.With("files", len(filePaths)).Info("Finished listing directory")
 	return
 }
 
-// ProcessFiles parses the files and processes them
+// FileError is the error of a single file of ProcessFiles
+type FileError struct {
+	FilePath string
+	Err      error
+}
+
+func (e *FileError) Error() string {
+	return e.FilePath + ": " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// ProcessFiles parses the files and processes them.
+// The part files are processed by Workers goroutines, the merged ids files are processed afterwards, one by one.
+// The errors of the files are returned as joined *FileError.
+// With a StateHandler, the files that are already finished are skipped, see ParseFile.
+// Use ProcessFilesContext to get the run report.
 func (p *Processor) ProcessFiles(filePaths []string) (err error) {
+	_, err = p.ProcessFilesContext(context.Background(), filePaths)
+	return err
+}
+
+// ProcessFilesContext is ProcessFiles with a context, it returns the report of all started files.
+// If the context is cancelled, no further files are started and the running files stop at the next line boundary.
+// With a StateHandler, the finished files and lines are stored, so that the next run resumes where this one stopped.
+func (p *Processor) ProcessFilesContext(ctx context.Context, filePaths []string) (report *RunReport, err error) {
 	logger := slog.With("method", "ProcessFiles")
-	total := len(filePaths)
-	// process all files
+	report = &RunReport{StartedAt: time.Now()}
+	fileReports := make([]*FileReport, len(filePaths))
+	progress := p.newRunProgress(filePaths)
+	stopProgress := make(chan struct{})
+	defer close(stopProgress)
+	go progress.run(p.ProgressInterval, stopProgress)
+
+	// the merged ids are processed strictly after all entity files
+	var entityFiles, mergedIdsFiles []int // indexes of the file paths
 	for i, filePath := range filePaths {
-		if strings.Contains(filePath, "merged_ids") {
-			// handle merged ids file
-			if p.MergedIdHandler != nil {
-				errFile := ParseMergedIDsFile(filePath, p.MergedIdHandler)
+		if containsMergedIDs(filePath) {
+			mergedIdsFiles = append(mergedIdsFiles, i)
+		} else {
+			entityFiles = append(entityFiles, i)
+		}
+	}
+
+	workers := p.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if p.StateHandler != nil && workers > 1 {
+		// the state handler keeps track of a single current file
+		logger.With("workers", workers).Warn("Processing the files one by one, because a state handler is set")
+		workers = 1
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	failed := func() bool {
+		if ctx.Err() != nil {
+			return true
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return len(errs) > 0 && !p.ContinueOnFileError
+	}
+
+	total := len(filePaths)
+	var started atomic.Int64
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range queue {
+				filePath := filePaths[index]
+				fileReport := &FileReport{FilePath: filePath}
+				fileReports[index] = fileReport
+				percent := float64(started.Add(1)-1) / float64(total) * 100
+				progressStr := fmt.Sprintf("%.2f", percent)
+				logger.
+					With("filePath", filePath).
+					With("progress", progressStr).
+					Info("Processing file")
+				_, errFile := p.parseFile(ctx, filePath, fileReport, progress)
+				progress.fileDone(filePath)
 				if errFile != nil {
 					logger.
 						With("err", errFile).
 						With("filePath", filePath).
-						Error("error while parsing the merged id file")
-					return errFile
+						Error("error while parsing the file")
+					mu.Lock()
+					errs = append(errs, &FileError{FilePath: filePath, Err: errFile})
+					mu.Unlock()
 				}
 			}
-		} else {
-			progress := float64(i) / float64(total) * 100
-			progressStr := fmt.Sprintf("%.2f", progress)
-			// handle other files
-			logger.
-				With("filePath", filePath).
-				With("progress", progressStr).
-				Info("Processing file")
-			_, errFile := p.ParseFile(filePath)
+		}()
+	}
+	for _, index := range entityFiles {
+		// stop handing out files after the first error
+		if failed() {
+			break
+		}
+		queue <- index
+	}
+	close(queue)
+	wg.Wait()
+
+	// handle merged ids files
+	if p.MergedIdHandler != nil {
+		for _, index := range mergedIdsFiles {
+			if failed() {
+				break
+			}
+			filePath := filePaths[index]
+			fileReports[index] = &FileReport{FilePath: filePath}
+			errFile := p.parseMergedIDsFile(filePath, fileReports[index])
+			progress.fileDone(filePath)
 			if errFile != nil {
 				logger.
 					With("err", errFile).
 					With("filePath", filePath).
-					Error("error while parsing the file")
-				return errFile
+					Error("error while parsing the merged id file")
+				errs = append(errs, &FileError{FilePath: filePath, Err: errFile})
 			}
+		}
+	}
+	// files that were not started because of the context are not part of the errors
+	if ctx.Err() != nil && !errors.Is(errors.Join(errs...), ctx.Err()) {
+		errs = append(errs, fmt.Errorf("processing stopped: %w", ctx.Err()))
+	}
+	for _, fileReport := range fileReports {
+		if fileReport != nil {
+			report.Files = append(report.Files, fileReport)
+		}
+	}
+	report.finish()
+	logger.
+		With("files", len(report.Files)).
+		With("lines", report.Lines).
+		With("skippedLines", report.SkippedLines).
+		With("duration", report.Duration.String()).
+		With("linesPerSecond", fmt.Sprintf("%.0f", report.LinesPerSecond)).
+		Info("Finished processing files")
+	if len(errs) > 0 {
+		logger.With("failedFiles", len(errs)).Error("error while processing the files")
+	}
+	return report, errors.Join(errs...)
+}
 
+// parseMergedIDsFile parses a merged ids file, registers it in the StateHandler and fills the report of the file
+func (p *Processor) parseMergedIDsFile(filePath string, report *FileReport) (err error) {
+	start := time.Now()
+	defer func() {
+		report.Duration = time.Since(start)
+		if err != nil {
+			report.Error = err.Error()
 		}
+	}()
+	report.EntityType, _ = GetEntityType(filePath)
+	if info, errStat := os.Stat(filePath); errStat == nil {
+		report.CompressedBytes = info.Size()
 	}
-	return
+	if p.StateHandler != nil {
+		done, errState := p.StateHandler.RegisterOrSkipEntityFile(filePath)
+		if errState != nil {
+			return errState
+		}
+		if done {
+			report.Skipped = true
+			return nil
+		}
+	}
+	// the merges are not counted as lines, only the file
+	p.Metrics.startFile(report.EntityType, filePath)
+	defer p.Metrics.finishFile(report.EntityType, filePath)
+	summary, err := ParseMergedIDsFileSince(filePath, p.MergedIdsSince, p.MergedIdHandler)
+	if summary != nil {
+		report.Lines = summary.Handled
+		report.SkippedLines = summary.Invalid + summary.Skipped
+	}
+	if err != nil {
+		return err
+	}
+	if p.StateHandler != nil {
+		p.StateHandler.MarkEntityFileAsFinished()
+	}
+	return nil
 }
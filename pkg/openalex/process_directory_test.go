@@ -1,9 +1,13 @@
 package openalex
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -24,7 +28,7 @@ func TestReadFromDirectory(t *testing.T) {
 		MergedIdHandler: PrintMergedIdRecordHandler,
 	}
 	//Change to according directory
-	err := p.ProcessDirectory()
+	_, err := p.ProcessDirectory()
 	if err != nil {
 		t.Error(err)
 	}
@@ -58,3 +62,144 @@ func TestOrderByMergedIDsLast(t *testing.T) {
 	}
 
 }
+
+func TestProcessFilesWorkers(t *testing.T) {
+	dir := t.TempDir()
+	var filePaths []string
+	for i := 0; i < 8; i++ {
+		filePath := filepath.Join(dir, "works", "updated_date=2023-05-01", fmt.Sprintf("part_%03d.gz", i))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, gzipBytes(t, `{"id":"W1"}`, `{"id":"W2"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		filePaths = append(filePaths, filePath)
+	}
+	mergedIdsPath := filepath.Join(dir, "merged_ids", "works", "2023-05-01.csv.gz")
+	if err := os.MkdirAll(filepath.Dir(mergedIdsPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mergedIdsPath, gzipBytes(t, "merge_date,id,merge_into_id", "2023-05-01,W1,W2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	lines := 0
+	linesBeforeMerges := -1
+	p := Processor{
+		DirectoryPath: dir,
+		Workers:       4,
+		LineHandler: func(filePath string, line string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			lines++
+			return nil
+		},
+		MergedIdHandler: func(fileEntityType FileEntityType, mergedID MergedID) error {
+			mu.Lock()
+			defer mu.Unlock()
+			linesBeforeMerges = lines
+			return nil
+		},
+	}
+	if _, err := p.ProcessDirectory(); err != nil {
+		t.Fatal(err)
+	}
+	if lines != 16 || linesBeforeMerges != 16 {
+		t.Error("expected the merged ids after all lines", lines, linesBeforeMerges)
+	}
+
+	// the errors are reported per file
+	errHandler := errors.New("handler error")
+	p.ContinueOnFileError = true
+	p.LineHandler = func(filePath string, line string) error {
+		if strings.HasSuffix(filePath, "part_003.gz") || strings.HasSuffix(filePath, "part_005.gz") {
+			return errHandler
+		}
+		return nil
+	}
+	linesBeforeMerges = -1
+	err := p.ProcessFiles(append(filePaths, mergedIdsPath))
+	if !errors.Is(err, errHandler) {
+		t.Fatal("expected the handler error", err)
+	}
+	var fileErr *FileError
+	if !errors.As(err, &fileErr) || !strings.Contains(err.Error(), "part_003.gz") || !strings.Contains(err.Error(), "part_005.gz") {
+		t.Error("expected errors of both files", err)
+	}
+	if linesBeforeMerges == -1 {
+		t.Error("expected the merged ids to be processed")
+	}
+
+	// without ContinueOnFileError the merged ids are not processed after an error
+	p.ContinueOnFileError = false
+	linesBeforeMerges = -1
+	if err = p.ProcessFiles(append(filePaths, mergedIdsPath)); !errors.Is(err, errHandler) {
+		t.Fatal("expected the handler error", err)
+	}
+	if linesBeforeMerges != -1 {
+		t.Error("expected no merged ids after an error")
+	}
+}
+
+func TestProcessDirectoryContextResume(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "data", "works", "updated_date=2023-05-01", "part_000.gz")
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, gzipBytes(t, `{"id":"W1"}`, `{"id":"W2"}`, `{"id":"W3"}`, `{"id":"W4"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var lines []string
+	p := Processor{
+		DirectoryPath: filepath.Join(dir, "data"),
+		StateHandler:  NewStateHandler("state.db", dir, filepath.Join(dir, "data")),
+		LineHandler: func(filePath string, line string) error {
+			lines = append(lines, line)
+			if len(lines) == 2 {
+				cancel()
+			}
+			return nil
+		},
+	}
+	_, err := p.ProcessDirectoryContext(ctx)
+	if !errors.Is(err, context.Canceled) || len(lines) != 2 {
+		t.Fatal("expected a cancelled run after two lines", err, lines)
+	}
+
+	// the next run resumes after the finished lines
+	lines = nil
+	if _, err = p.ProcessDirectoryContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0] != `{"id":"W3"}` {
+		t.Error("expected the remaining lines", lines)
+	}
+
+	// finished files are skipped
+	lines = nil
+	if _, err = p.ProcessDirectoryContext(context.Background()); err != nil || len(lines) != 0 {
+		t.Error("expected no lines", lines, err)
+	}
+
+	// a cancelled context does not start any file
+	if _, err = p.ProcessDirectoryContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Error("expected a cancelled run", err)
+	}
+}
+
+func TestProcessDirectoryWalkError(t *testing.T) {
+	p := Processor{DirectoryPath: filepath.Join(t.TempDir(), "missing"), LineHandler: PrintLineHandler}
+	report, err := p.ProcessDirectory()
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Error("expected a walk error", err)
+	}
+	if report == nil || len(report.Files) != 0 {
+		t.Error("expected an empty report", report)
+	}
+}
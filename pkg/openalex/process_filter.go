@@ -0,0 +1,116 @@
+package openalex
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrInvalidFileFilter is returned when the file filter is invalid
+var ErrInvalidFileFilter = errors.New("invalid file filter")
+
+// FileFilter selects the files that are processed by the Processor.
+// The zero value selects all files.
+// The patterns are matched with path.Match against the path relative to the directory, e.g. works/updated_date=2024-*/*.gz,
+// patterns without a slash are matched against the file name, e.g. part_00*.gz.
+type FileFilter struct {
+	EntityTypes        []FileEntityType // entity types to process, all entity types if empty
+	ExcludeEntityTypes []FileEntityType // entity types to skip, e.g. concepts
+	UpdatedDateFrom    string           // inclusive lower bound of the updated_date partitions, e.g. 2024-01-01
+	UpdatedDateTo      string           // inclusive upper bound of the updated_date partitions
+	IncludePatterns    []string         // only files that match one of the patterns, all files if empty
+	ExcludePatterns    []string         // files that match one of the patterns are skipped
+}
+
+// Validate checks the format of the dates and the patterns
+func (f *FileFilter) Validate() error {
+	for _, date := range []string{f.UpdatedDateFrom, f.UpdatedDateTo} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			return fmt.Errorf("%w: %s is not a date of the format YYYY-MM-DD", ErrInvalidFileFilter, date)
+		}
+	}
+	for _, pattern := range append(slices.Clone(f.IncludePatterns), f.ExcludePatterns...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidFileFilter, pattern, err)
+		}
+	}
+	return nil
+}
+
+// IsEmpty returns true if the filter selects all files
+func (f *FileFilter) IsEmpty() bool {
+	return len(f.EntityTypes) == 0 && len(f.ExcludeEntityTypes) == 0 &&
+		f.UpdatedDateFrom == "" && f.UpdatedDateTo == "" &&
+		len(f.IncludePatterns) == 0 && len(f.ExcludePatterns) == 0
+}
+
+// Includes checks if a file below the directory is selected.
+// The date range only applies to files in updated_date partitions, the merged ids files are selected by their entity type.
+func (f *FileFilter) Includes(directoryPath string, filePath string) bool {
+	relPath, err := filepath.Rel(directoryPath, filePath)
+	if err != nil {
+		relPath = filePath
+	}
+	relPath = filepath.ToSlash(relPath)
+
+	if len(f.EntityTypes) > 0 || len(f.ExcludeEntityTypes) > 0 {
+		entityType, errType := GetEntityType(relPath)
+		if errType != nil {
+			return false
+		}
+		if len(f.EntityTypes) > 0 && !slices.Contains(f.EntityTypes, entityType) {
+			return false
+		}
+		if slices.Contains(f.ExcludeEntityTypes, entityType) {
+			return false
+		}
+	}
+
+	if updatedDate := strings.TrimPrefix(getUpdatedDate(relPath), "updated_date="); updatedDate != "" {
+		if f.UpdatedDateFrom != "" && updatedDate < f.UpdatedDateFrom {
+			return false
+		}
+		if f.UpdatedDateTo != "" && updatedDate > f.UpdatedDateTo {
+			return false
+		}
+	}
+
+	if len(f.IncludePatterns) > 0 && !matchesAnyPattern(f.IncludePatterns, relPath) {
+		return false
+	}
+	return !matchesAnyPattern(f.ExcludePatterns, relPath)
+}
+
+// matchesAnyPattern checks if the slash separated path matches one of the patterns
+func matchesAnyPattern(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		name := relPath
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(relPath)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filterFiles returns the files that are selected by the filter
+func (f *FileFilter) filterFiles(directoryPath string, filePaths []string) (result []string) {
+	if f.IsEmpty() {
+		return filePaths
+	}
+	for _, filePath := range filePaths {
+		if f.Includes(directoryPath, filePath) {
+			result = append(result, filePath)
+		}
+	}
+	return result
+}
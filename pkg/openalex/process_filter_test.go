@@ -0,0 +1,87 @@
+package openalex
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileFilterIncludes(t *testing.T) {
+	dir := filepath.Join("openalex", "data")
+	var tests = []struct {
+		name     string
+		filter   FileFilter
+		filePath string
+		expected bool
+	}{
+		{"empty", FileFilter{}, "works/updated_date=2023-05-01/part_000.gz", true},
+		{"entity type", FileFilter{EntityTypes: []FileEntityType{WorksFileEntityType}}, "works/updated_date=2023-05-01/part_000.gz", true},
+		{"other entity type", FileFilter{EntityTypes: []FileEntityType{WorksFileEntityType}}, "authors/updated_date=2023-05-01/part_000.gz", false},
+		{"excluded entity type", FileFilter{ExcludeEntityTypes: []FileEntityType{ConceptsFileEntityType}}, "concepts/updated_date=2023-05-01/part_000.gz", false},
+		{"merged ids entity type", FileFilter{ExcludeEntityTypes: []FileEntityType{ConceptsFileEntityType}}, "merged_ids/concepts/2023-05-01.csv.gz", false},
+		{"from", FileFilter{UpdatedDateFrom: "2024-01-01"}, "works/updated_date=2023-12-31/part_000.gz", false},
+		{"from inclusive", FileFilter{UpdatedDateFrom: "2024-01-01"}, "works/updated_date=2024-01-01/part_000.gz", true},
+		{"to", FileFilter{UpdatedDateTo: "2024-01-01"}, "works/updated_date=2024-01-02/part_000.gz", false},
+		{"date of merged ids", FileFilter{UpdatedDateFrom: "2024-01-01"}, "merged_ids/works/2023-05-01.csv.gz", true},
+		{"include path", FileFilter{IncludePatterns: []string{"works/updated_date=2024-*/*"}}, "works/updated_date=2024-02-01/part_000.gz", true},
+		{"include other path", FileFilter{IncludePatterns: []string{"works/updated_date=2024-*/*"}}, "works/updated_date=2023-02-01/part_000.gz", false},
+		{"exclude name", FileFilter{ExcludePatterns: []string{"part_00[1-9].gz"}}, "works/updated_date=2023-02-01/part_001.gz", false},
+		{"exclude other name", FileFilter{ExcludePatterns: []string{"part_00[1-9].gz"}}, "works/updated_date=2023-02-01/part_000.gz", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			if result := tt.filter.Includes(dir, filepath.Join(dir, filepath.FromSlash(tt.filePath))); result != tt.expected {
+				t.Error("unexpected result", tt.filePath, result)
+			}
+		})
+	}
+
+	for _, filter := range []FileFilter{{UpdatedDateFrom: "2024-1-1"}, {ExcludePatterns: []string{"[part"}}} {
+		if err := filter.Validate(); !errors.Is(err, ErrInvalidFileFilter) {
+			t.Error("expected an invalid filter", filter, err)
+		}
+	}
+}
+
+func TestProcessorFilter(t *testing.T) {
+	dir := t.TempDir()
+	for _, filePath := range []string{
+		"works/updated_date=2023-05-01/part_000.gz",
+		"works/updated_date=2024-05-01/part_000.gz",
+		"concepts/updated_date=2024-05-01/part_000.gz",
+		"merged_ids/works/2024-05-01.csv.gz",
+	} {
+		filePath = filepath.Join(dir, filepath.FromSlash(filePath))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, gzipBytes(t, `{"id":"W1"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := Processor{
+		DirectoryPath: dir,
+		Filter: FileFilter{
+			ExcludeEntityTypes: []FileEntityType{ConceptsFileEntityType},
+			UpdatedDateFrom:    "2024-01-01",
+		},
+	}
+	filePaths, err := p.GetFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filePaths) != 2 ||
+		filePaths[0] != filepath.Join(dir, "works", "updated_date=2024-05-01", "part_000.gz") ||
+		filePaths[1] != filepath.Join(dir, "merged_ids", "works", "2024-05-01.csv.gz") {
+		t.Error("unexpected files", filePaths)
+	}
+
+	p.Filter.UpdatedDateTo = "2024"
+	if _, err = p.GetFiles(); !errors.Is(err, ErrInvalidFileFilter) {
+		t.Error("expected an invalid filter", err)
+	}
+}
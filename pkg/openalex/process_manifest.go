@@ -0,0 +1,79 @@
+package openalex
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// ErrMissingFile is returned when a file that is listed in a manifest does not exist
+var ErrMissingFile = errors.New("file listed in manifest is missing")
+
+// ErrNoManifest is returned when the directory does not contain any manifest
+var ErrNoManifest = errors.New("no manifest found")
+
+// MissingFilePolicy defines how the processor handles files that are listed in a manifest but do not exist
+type MissingFilePolicy int
+
+const (
+	MissingFileFail MissingFilePolicy = iota // return ErrMissingFile
+	MissingFileWarn                          // log a warning and skip the file
+)
+
+// getManifestFiles returns the part files that are listed in the local manifests in manifest order,
+// followed by the merged ids files.
+// The DirectoryPath must be the data directory that contains the entity directories.
+func (p *Processor) getManifestFiles() (filePaths []string, err error) {
+	logger := slog.With("directoryPath", p.DirectoryPath)
+	manifests, err := ReadManifestsFromDirectory(p.DirectoryPath)
+	if err != nil {
+		logger.With("err", err).Error("error while reading the manifests")
+		return nil, err
+	}
+	if len(manifests) == 0 {
+		logger.With("err", ErrNoManifest).Error("no manifest in directory")
+		return nil, ErrNoManifest
+	}
+	for _, manifestUrl := range AllManifestUrls {
+		entityType, errType := GetEntityType(manifestUrl.Key())
+		if errType != nil {
+			return nil, errType
+		}
+		manifest, ok := manifests[entityType]
+		if !ok {
+			continue
+		}
+		for _, entry := range manifest.Entries {
+			localPath := entry.LocalPath(p.DirectoryPath)
+			// filtered files do not need to exist
+			if !p.Filter.Includes(p.DirectoryPath, localPath) {
+				continue
+			}
+			if _, errStat := os.Stat(localPath); errStat != nil {
+				if p.MissingFilePolicy == MissingFileWarn {
+					logger.With("err", errStat).With("filePath", localPath).Warn("Skipping missing file")
+					continue
+				}
+				err = fmt.Errorf("%w: %s", ErrMissingFile, localPath)
+				logger.With("err", err).Error("missing file")
+				return nil, err
+			}
+			filePaths = append(filePaths, localPath)
+		}
+	}
+
+	// the merged ids files are not listed in any manifest
+	mergedIdsDir := filepath.Join(p.DirectoryPath, "merged_ids")
+	if _, errStat := os.Stat(mergedIdsDir); errStat == nil {
+		var mergedIdsFiles []string
+		err = filepath.Walk(mergedIdsDir, visit(&mergedIdsFiles))
+		if err != nil {
+			logger.With("err", err).Error("error while walking the merged ids directory")
+			return nil, err
+		}
+		filePaths = append(filePaths, mergedIdsFiles...)
+	}
+	return filePaths, nil
+}
@@ -0,0 +1,76 @@
+package openalex
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessorUseManifests(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"works/manifest": []byte(`{"entries": [
+			{"url": "s3://openalex/data/works/updated_date=2023-05-02/part_000.gz", "meta": {"content_length": 1, "record_count": 1}},
+			{"url": "s3://openalex/data/works/updated_date=2023-05-01/part_000.gz", "meta": {"content_length": 1, "record_count": 2}},
+			{"url": "s3://openalex/data/works/updated_date=2023-05-03/part_000.gz", "meta": {"content_length": 1, "record_count": 1}}
+		]}`),
+		"works/updated_date=2023-05-02/part_000.gz":      gzipBytes(t, `{"id":"W3"}`),
+		"works/updated_date=2023-05-01/part_000.gz":      gzipBytes(t, `{"id":"W1"}`, `{"id":"W2"}`),
+		"works/updated_date=2023-05-01/part_001.gz":      gzipBytes(t, `{"id":"stray"}`),
+		"works/updated_date=2023-05-01/part_000.partial": []byte("half"),
+		"merged_ids/works/2023-05-01.csv.gz":             gzipBytes(t, "merge_date,id,merge_into_id", "2023-05-01,W4,W1"),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []string
+	var merged []string
+	p := Processor{
+		DirectoryPath: dir,
+		UseManifests:  true,
+		LineHandler: func(filePath string, line string) error {
+			ids = append(ids, line)
+			return nil
+		},
+		MergedIdHandler: func(fileEntityType FileEntityType, mergedID MergedID) error {
+			merged = append(merged, mergedID.ID)
+			return nil
+		},
+	}
+
+	// the third partition is missing
+	_, err := p.ProcessDirectory()
+	if !errors.Is(err, ErrMissingFile) {
+		t.Fatal("expected missing file error", err)
+	}
+	if len(ids) != 0 {
+		t.Error("no file must be processed", ids)
+	}
+
+	p.MissingFilePolicy = MissingFileWarn
+	_, err = p.ProcessDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ids, ",") != `{"id":"W3"},{"id":"W1"},{"id":"W2"}` {
+		t.Error("unexpected order or files", ids)
+	}
+	if strings.Join(merged, ",") != "W4" {
+		t.Error("unexpected merged ids", merged)
+	}
+
+	p.DirectoryPath = t.TempDir()
+	_, err = p.GetFiles()
+	if !errors.Is(err, ErrNoManifest) {
+		t.Error("expected no manifest error", err)
+	}
+}
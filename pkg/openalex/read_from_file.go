@@ -3,14 +3,17 @@ package openalex
 import (
 	"bufio"
 	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	jsoniter "github.com/json-iterator/go"
 )
@@ -35,6 +38,19 @@ const (
 	DomainsFileEntityType      FileEntityType = "domains"
 )
 
+// DirName returns the name of the directory of the entity type in the snapshot,
+// which is also the name of the REST API endpoint
+func (t FileEntityType) DirName() string {
+	switch t {
+	case InstitutionsFileEntityType:
+		return "institutions"
+	case PublishersFileEntityType:
+		return "publishers"
+	default:
+		return string(t)
+	}
+}
+
 func GetEntityType(filePath string) (result FileEntityType, err error) {
 	if strings.Contains(filePath, "author") {
 		result = AuthorsFileEntityType
@@ -73,6 +89,9 @@ func getUpdatedDate(filePath string) string {
 	return match
 }
 
+// maxLineCapacity is the max length of a line
+const maxLineCapacity = 500 * 1024 * 1024 // 500 MB
+
 // LineHandler is a function that handles a line of a file
 type LineHandler func(filePath string, line string) error
 
@@ -83,50 +102,102 @@ func PrintLineHandler(filePath string, line string) error {
 }
 
 // ParseFile takes a file name and reads the data from within the file and parses every line it into structs
+// With a StateHandler, the file is registered and skipped if it is already finished,
+// the finished lines of an unfinished file are skipped.
+// The state of the file and its lines is marked as finished afterwards.
 func (p *Processor) ParseFile(filePath string) (count int, err error) {
+	return p.ParseFileContext(context.Background(), filePath)
+}
+
+// ParseFileContext is ParseFile with a context.
+// If the context is cancelled, the file is stopped before the next line
+// and an error that wraps the error of the context is returned.
+// With a StateHandler, the file is skipped if it is already finished and marked as finished afterwards.
+func (p *Processor) ParseFileContext(ctx context.Context, filePath string) (count int, err error) {
+	return p.parseFile(ctx, filePath, &FileReport{FilePath: filePath}, nil)
+}
+
+// parseFile parses a file and fills the report of the file, the progress of the run is optional
+func (p *Processor) parseFile(ctx context.Context, filePath string, report *FileReport, progress *runProgress) (count int, err error) {
 	logger := slog.With("filePath", filePath)
 	count = 0
+	start := time.Now()
+	defer func() {
+		report.Lines = count
+		report.Duration = time.Since(start)
+		if err != nil {
+			report.Error = err.Error()
+		}
+	}()
 
-	// init the read
-	var scanner *bufio.Scanner
+	if p.StateHandler != nil {
+		fileDone, errState := p.StateHandler.RegisterOrSkipEntityFile(filePath)
+		if errState != nil {
+			logger.With("err", errState).Error("error registering file")
+			return count, errState
+		}
+		if fileDone {
+			logger.Info("Skipping finished file")
+			report.Skipped = true
+			progress.addFinishedFile(filePath)
+			return count, nil
+		}
+	}
+
+	// open the file
+	file, errOpen := os.Open(filePath)
+	if errOpen != nil {
+		slog.With("err", errOpen).Error("error opening file")
+		return count, errOpen
+	}
+	defer file.Close()
+	if info, errStat := file.Stat(); errStat == nil {
+		report.CompressedBytes = info.Size()
+	}
+	var reader io.Reader = file
 
 	// check if rawContent is compressed
 	fileExtension := path.Ext(filePath)
 	if fileExtension == ".gz" {
-		// if file has a .gz ending
-		compressedFile, errOpen := os.Open(filePath)
-		if errOpen != nil {
-			slog.With("err", errOpen).Error("error opening file")
-			return count, errOpen
-		}
-		defer compressedFile.Close()
 		// get the raw content of the file
-		rawContent, errGzip := gzip.NewReader(compressedFile)
+		rawContent, errGzip := gzip.NewReader(file)
 		if errGzip != nil {
 			slog.With("err", errGzip).Error("error opening gz file")
 			return count, errGzip
 		}
-		scanner = bufio.NewScanner(rawContent)
-	} else {
-		// if file has no file ending that indicates compression
-		fileContent, errOpen := os.Open(filePath)
-		if errOpen != nil {
-			slog.With("err", errOpen).Error("error opening file")
-			return count, errOpen
-		}
-		defer fileContent.Close()
-		// init scanner
-		scanner = bufio.NewScanner(fileContent)
+		reader = rawContent
 	}
-	// set the max capacity of the scanner
-	const maxCapacity = 500 * 1024 * 1024 // 500 MB
-	buf := make([]byte, maxCapacity)
-	scanner.Buffer(buf, maxCapacity)
+	// init the read
+	scanner := bufio.NewScanner(reader)
+	// set the max capacity of the scanner,
+	// the buffer grows up to it, so that concurrent workers do not allocate it up front
+	buf := make([]byte, 0, 1024*1024)
+	scanner.Buffer(buf, maxLineCapacity)
+
+	// the entity type is needed to rewrite the merged ids
+	entityType, errType := GetEntityType(filePath)
+	if errType != nil && p.IDRewriter != nil {
+		logger.With("err", errType).Error("error getting file entity type")
+		return count, errType
+	}
+	report.EntityType = entityType
+	metrics := p.Metrics.startFile(entityType, filePath)
+	defer p.Metrics.finishFile(entityType, filePath)
 
 	// iterate over the lines
 	entityLineIndex := 0
+	lineErrors := 0
 	for scanner.Scan() {
+		// stop at the line boundary, the finished lines are already stored in the state handler
+		if errCtx := ctx.Err(); errCtx != nil {
+			logger.With("line", entityLineIndex).Warn("Stopped processing file")
+			return count, fmt.Errorf("stopped before line %d: %w", entityLineIndex+1, errCtx)
+		}
 		entityLineIndex++
+		lineBytes := int64(len(scanner.Bytes()) + 1)
+		report.UncompressedBytes += lineBytes
+		progress.addLine(lineBytes)
+		metrics.addRead(lineBytes)
 		if p.StateHandler != nil {
 			entityLineName := "entity_line_" + strconv.Itoa(entityLineIndex) + "_end"
 			entityLineDone, _ := p.StateHandler.RegisterOrSkipEntityLine(entityLineName)
@@ -135,17 +206,25 @@ func (p *Processor) ParseFile(filePath string) (count int, err error) {
 			}
 		}
 		line := scanner.Text()
-		// handle the parsed line
-		err = p.LineHandler(filePath, line)
-		if err != nil {
-			logger.With("err", err).Error("error handling parsed entity")
-			return count, err
+		errLine := p.handleLine(entityType, filePath, line)
+		if errLine != nil {
+			metrics.addHandlerError()
+			lineErrors++
+			report.SkippedLines = lineErrors
+			// the error policy decides if the line is skipped
+			err = p.handleLineError(ctx, filePath, entityLineIndex, line, errLine, lineErrors)
+			if err != nil {
+				logger.With("err", err).With("line", entityLineIndex).Error("error handling parsed entity")
+				return count, err
+			}
+		} else {
+			// increment the count of the parsed record
+			count++
+			metrics.addLines(1)
 		}
 		if p.StateHandler != nil {
 			p.StateHandler.MarkEntityLineAsFinished()
 		}
-		// increment the count of the parsed record
-		count++
 	}
 
 	err = scanner.Err()
@@ -153,6 +232,24 @@ func (p *Processor) ParseFile(filePath string) (count int, err error) {
 		logger.With("err", err).Error("error scanning file")
 		return count, err
 	}
+	if lineErrors > 0 {
+		logger.With("skipped", lineErrors).Warn("Skipped lines of file")
+	}
+	if p.StateHandler != nil {
+		p.StateHandler.MarkEntityFileAsFinished()
+	}
 
 	return count, nil
 }
+
+// handleLine rewrites the merged ids of a line and passes it to the line handler
+func (p *Processor) handleLine(entityType FileEntityType, filePath string, line string) (err error) {
+	if p.IDRewriter != nil {
+		line, _, err = p.IDRewriter.RewriteLine(entityType, line)
+		if err != nil {
+			return fmt.Errorf("error rewriting merged ids: %w", err)
+		}
+	}
+	// handle the parsed line
+	return p.LineHandler(filePath, line)
+}
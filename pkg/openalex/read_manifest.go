@@ -1,59 +1,111 @@
 package openalex
 
 import (
+	"context"
 	"errors"
-	"io"
+	"fmt"
 	"log/slog"
-	"net/http"
+	"net/url"
+	"os"
 )
 
 // ErrStatusNotOK is returned when the status code is not OK
 var ErrStatusNotOK = errors.New("status code is not OK")
 
-// ReadManifestFromS3Url reads the manifest file from S3 and returns a Manifest struct
+// ErrUnsupportedManifestUrl is returned when a manifest url is neither in the OpenAlex bucket nor a http(s) url
+var ErrUnsupportedManifestUrl = errors.New("unsupported manifest url")
+
+// ReadManifestFromS3Url reads the manifest file from S3 and returns a Manifest struct.
+// It uses the DefaultHttpConfig, see FetchManifest for a context and a custom config.
 func ReadManifestFromS3Url(s3Url ManifestUrl) (result *Manifest, err error) {
-	logger := slog.With("s3Url", s3Url)
-	// Create an HTTP GET request
-	req, err := http.NewRequest("GET", string(s3Url), nil)
+	return FetchManifest(context.Background(), DefaultHttpConfig(), s3Url)
+}
+
+// FetchManifest reads the manifest from the bucket of the config, e.g. a mirror or a local test server.
+// Manifests of the OpenAlex bucket and object keys are read from the base url of the config,
+// other http(s) urls are read from their own host.
+func FetchManifest(ctx context.Context, config HttpConfig, manifestUrl ManifestUrl) (result *Manifest, err error) {
+	if config.BaseUrl == "" {
+		config.BaseUrl = DefaultSnapshotBaseUrl
+	}
+	logger := slog.With("manifestUrl", manifestUrl).With("baseUrl", config.BaseUrl)
+	fetchUrl, err := manifestFetchUrl(config, manifestUrl)
 	if err != nil {
-		logger.With("error", err).Error("Failed to create HTTP request")
+		logger.With("err", err).Error("Failed to resolve manifest url")
 		return nil, err
 	}
-
-	// Create an HTTP client
-	client := &http.Client{}
-
-	// Send the request
-	resp, err := client.Do(req)
+	body, err := config.getBytes(ctx, fetchUrl)
 	if err != nil {
-		logger.With("error", err).Error("Failed to send HTTP request")
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// Check if the response status code is OK
-	if resp.StatusCode != http.StatusOK {
-		err = ErrStatusNotOK
-		logger.
-			With("statusCode", resp.StatusCode).
-			With("err", err).
-			Error("Failed to fetch S3 object")
+	// Unmarshal the JSON into the manifest struct
+	var manifest Manifest
+	err = json.Unmarshal(body, &manifest)
+	if err != nil {
+		logger.With("error", err).Error("Failed to unmarshal JSON")
 		return nil, err
 	}
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
+	return &manifest, nil
+}
+
+// manifestFetchUrl returns the url the manifest is read from
+func manifestFetchUrl(config HttpConfig, manifestUrl ManifestUrl) (string, error) {
+	u, err := url.Parse(string(manifestUrl))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrUnsupportedManifestUrl, manifestUrl, err)
+	}
+	defaultUrl, _ := url.Parse(DefaultSnapshotBaseUrl)
+	switch {
+	case u.Scheme == "" && u.Host == "":
+		// an object key, e.g. data/works/manifest
+		return config.url(u.Path), nil
+	case u.Scheme == "s3" && u.Host == "openalex",
+		(u.Scheme == "https" || u.Scheme == "http") && u.Host == defaultUrl.Host:
+		return config.url(u.Path), nil
+	case u.Scheme == "https" || u.Scheme == "http":
+		return u.String(), nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedManifestUrl, manifestUrl)
+	}
+}
+
+// ReadManifestFromFile reads a local manifest file, e.g. /openalex/data/works/manifest
+func ReadManifestFromFile(filePath string) (result *Manifest, err error) {
+	logger := slog.With("filePath", filePath)
+	data, err := os.ReadFile(filePath)
 	if err != nil {
+		logger.With("error", err).Error("Failed to read manifest file")
 		return nil, err
 	}
-
-	// Unmarshal the JSON into the manifest struct
 	var manifest Manifest
-	err = json.Unmarshal(body, &manifest)
+	err = json.Unmarshal(data, &manifest)
 	if err != nil {
 		logger.With("error", err).Error("Failed to unmarshal JSON")
 		return nil, err
 	}
-
 	return &manifest, nil
 }
+
+// ReadManifestsFromDirectory reads the manifests of all entity types from a local data directory,
+// i.e. the directory that contains the entity directories, e.g. /openalex/data.
+// Entity types without a manifest file are skipped.
+func ReadManifestsFromDirectory(dataDir string) (result map[FileEntityType]*Manifest, err error) {
+	result = make(map[FileEntityType]*Manifest)
+	for _, manifestUrl := range AllManifestUrls {
+		filePath := manifestUrl.LocalPath(dataDir)
+		if _, errStat := os.Stat(filePath); errors.Is(errStat, os.ErrNotExist) {
+			continue
+		}
+		entityType, errType := GetEntityType(manifestUrl.Key())
+		if errType != nil {
+			return nil, errType
+		}
+		result[entityType], err = ReadManifestFromFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
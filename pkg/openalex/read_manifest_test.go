@@ -1,6 +1,8 @@
 package openalex
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -20,3 +22,27 @@ func TestReadManifest(t *testing.T) {
 		}
 	}
 }
+
+func TestReadManifestsFromDirectory(t *testing.T) {
+	manifests, err := ReadManifestsFromDirectory("../../sample/openalex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 2 || manifests[WorksFileEntityType] == nil || manifests[AuthorsFileEntityType] == nil {
+		t.Fatal("expected the manifests of works and authors", manifests)
+	}
+	entry := manifests[WorksFileEntityType].Entries[0]
+	entityType, err := entry.EntityType()
+	if err != nil || entityType != WorksFileEntityType {
+		t.Error("unexpected entity type", entityType, err)
+	}
+	if entry.UpdatedDate() != "2023-05-16" || entry.Meta.RecordCount != 27 {
+		t.Error("unexpected entry", entry.UpdatedDate(), entry.Meta)
+	}
+	if entry.LocalPath("../../sample/openalex") != filepath.Join("../../sample/openalex", "works", "updated_date=2023-05-16", "part_000.gz") {
+		t.Error("unexpected local path", entry.LocalPath("../../sample/openalex"))
+	}
+	if _, err = os.Stat(entry.LocalPath("../../sample/openalex")); err != nil {
+		t.Error(err)
+	}
+}
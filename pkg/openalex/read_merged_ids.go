@@ -8,13 +8,90 @@ import (
 	"log/slog"
 	"os"
 	"strings"
+	"time"
 )
 
+// MergeDate is the date of a merge, e.g. 2023-04-13
+type MergeDate struct {
+	time.Time
+}
+
+// ParseMergeDate parses a date in the format 2006-01-02
+func ParseMergeDate(value string) (MergeDate, error) {
+	t, err := time.Parse(time.DateOnly, strings.TrimSpace(value))
+	if err != nil {
+		return MergeDate{}, err
+	}
+	return MergeDate{Time: t}, nil
+}
+
+// String returns the date in the format 2006-01-02, or an empty string for the zero date
+func (d MergeDate) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Format(time.DateOnly)
+}
+
+// MarshalJSON encodes the date in the format 2006-01-02
+func (d MergeDate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON decodes a date in the format 2006-01-02
+func (d *MergeDate) UnmarshalJSON(data []byte) error {
+	var value string
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+	if value == "" {
+		*d = MergeDate{}
+		return nil
+	}
+	*d, err = ParseMergeDate(value)
+	return err
+}
+
 // MergedID represents a row in the merged IDs file
 type MergedID struct {
-	MergeDate   string
-	ID          string
-	MergeIntoID string
+	MergeDate   MergeDate `json:"merge_date"`
+	ID          string    `json:"id"`
+	MergeIntoID string    `json:"merge_into_id"`
+}
+
+// EntityType returns the entity type of the merged id, which is derived from the prefix letter of the id
+func (m MergedID) EntityType() (FileEntityType, error) {
+	return GetEntityTypeOfID(m.ID)
+}
+
+// GetEntityTypeOfID returns the entity type of an OpenAlex ID by its prefix letter,
+// e.g. W2741809807 or https://openalex.org/W2741809807 is a work
+func GetEntityTypeOfID(id string) (FileEntityType, error) {
+	id = normalizeOpenAlexID(id)
+	if len(id) < 2 || id[1] < '0' || id[1] > '9' {
+		return "", ErrUnsupportedFileType
+	}
+	switch id[0] {
+	case 'W':
+		return WorksFileEntityType, nil
+	case 'A':
+		return AuthorsFileEntityType, nil
+	case 'S':
+		return SourcesFileEntityType, nil
+	case 'I':
+		return InstitutionsFileEntityType, nil
+	case 'C':
+		return ConceptsFileEntityType, nil
+	case 'P':
+		return PublishersFileEntityType, nil
+	case 'F':
+		return FundersFileEntityType, nil
+	case 'T':
+		return TopicsFileEntityType, nil
+	default:
+		return "", ErrUnsupportedFileType
+	}
 }
 
 // MergedIdRecordHandler is a function that handles a parsed line of a file
@@ -25,82 +102,111 @@ func PrintMergedIdRecordHandler(fileEntityType FileEntityType, mergedID MergedID
 	return nil
 }
 
-// ParseMergedIDsFile parses a CSV file (either plain or Gzipped) into a slice of CsvData
-func ParseMergedIDsFile(filePath string, fn MergedIdRecordHandler) (err error) {
+// MergedIDsSummary summarizes a parsed merged ids file
+type MergedIDsSummary struct {
+	FilePath       string         `json:"file_path"`
+	EntityType     FileEntityType `json:"entity_type"`
+	Rows           int            `json:"rows"`    // rows read, without the header
+	Invalid        int            `json:"invalid"` // rows with a wrong number of fields, an invalid date or an empty id
+	Skipped        int            `json:"skipped"` // valid rows that are not newer than the since date
+	Handled        int            `json:"handled"` // rows passed to the handler
+	FirstMergeDate MergeDate      `json:"first_merge_date"`
+	LastMergeDate  MergeDate      `json:"last_merge_date"`
+}
+
+// ParseMergedIDsFile parses a CSV file (either plain or Gzipped) and passes every valid row to the handler
+func ParseMergedIDsFile(filePath string, fn MergedIdRecordHandler) (summary *MergedIDsSummary, err error) {
+	return ParseMergedIDsFileSince(filePath, MergeDate{}, fn)
+}
+
+// ParseMergedIDsFileSince parses a CSV file (either plain or Gzipped) and passes the rows
+// that were merged after the since date to the handler, e.g. the date of the last processed snapshot.
+// The zero date passes all rows.
+func ParseMergedIDsFileSince(filePath string, since MergeDate, fn MergedIdRecordHandler) (summary *MergedIDsSummary, err error) {
 	logger := slog.With("filePath", filePath)
+	summary = &MergedIDsSummary{FilePath: filePath}
 
 	// get the file entity type
-	fileEntityType, err := GetEntityType(filePath)
+	summary.EntityType, err = GetEntityType(filePath)
 	if err != nil {
 		logger.With("err", err).Error("error getting file entity type")
 		return
 	}
 
-	var reader io.Reader
+	file, err := os.Open(filePath)
+	if err != nil {
+		logger.With("err", err).Error("error opening file")
+		return
+	}
+	defer file.Close()
+	var reader io.Reader = file
 
 	// Check if the file is Gzipped
 	if strings.HasSuffix(filePath, ".gz") {
-		// If it's Gzipped, open a Gzip reader
-		file, errOpen := os.Open(filePath)
-		if errOpen != nil {
-			err = errOpen
-			logger.With("err", err).Error("error opening file")
-			return
-		}
-		defer file.Close()
-
 		reader, err = gzip.NewReader(file)
 		if err != nil {
 			logger.With("err", err).Error("error opening gz file")
 			return
 		}
-	} else {
-		// If it's not Gzipped, open a regular file reader
-		file, errOpen := os.Open(filePath)
-		if err != nil {
-			err = errOpen
-			logger.With("err", err).Error("error opening file")
-			return
-		}
-		defer file.Close()
-
-		reader = file
 	}
 
 	// Create a CSV reader to read the data
 	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
 
-	// Read and parse the CSV data into a slice of CsvData structs
-	rowCount := 0
-	for {
-		record, err := csvReader.Read()
-		if err == io.EOF {
+	for first := true; ; first = false {
+		record, errRead := csvReader.Read()
+		if errRead == io.EOF {
 			break
-		} else if err != nil {
-			logger.With("err", err).Error("error reading CSV record")
-			return err
+		} else if errRead != nil {
+			logger.With("err", errRead).Error("error reading CSV record")
+			return summary, errRead
+		}
+		// the header is only expected in the first row
+		if first && len(record) > 0 && strings.TrimSpace(record[0]) == "merge_date" {
+			continue
 		}
+		summary.Rows++
 		if len(record) != 3 {
+			summary.Invalid++
 			logger.With("record", strings.Join(record, ";")).Warn("Invalid CSV record")
 			continue
 		}
-		if rowCount == 0 {
-			rowCount++
+		mergeDate, errDate := ParseMergeDate(record[0])
+		mergedID := MergedID{
+			MergeDate:   mergeDate,
+			ID:          strings.TrimSpace(record[1]),
+			MergeIntoID: strings.TrimSpace(record[2]),
+		}
+		if errDate != nil || mergedID.ID == "" || mergedID.MergeIntoID == "" {
+			summary.Invalid++
+			logger.With("record", strings.Join(record, ";")).Warn("Invalid CSV record")
 			continue
 		}
-		// generate the merged id struct
-		mergedID := MergedID{
-			MergeDate:   record[0],
-			ID:          record[1],
-			MergeIntoID: record[2],
+		if summary.FirstMergeDate.IsZero() || mergeDate.Before(summary.FirstMergeDate.Time) {
+			summary.FirstMergeDate = mergeDate
+		}
+		if mergeDate.After(summary.LastMergeDate.Time) {
+			summary.LastMergeDate = mergeDate
+		}
+		if !since.IsZero() && !mergeDate.After(since.Time) {
+			summary.Skipped++
+			continue
 		}
 		// process the merged id
-		errProcess := fn(fileEntityType, mergedID)
+		errProcess := fn(summary.EntityType, mergedID)
 		if errProcess != nil {
 			logger.With("err", errProcess).Error("error processing CSV record")
-			return errProcess
+			return summary, errProcess
 		}
-		rowCount++
+		summary.Handled++
 	}
-	return
+	logger.
+		With("rows", summary.Rows).
+		With("invalid", summary.Invalid).
+		With("skipped", summary.Skipped).
+		With("firstMergeDate", summary.FirstMergeDate.String()).
+		With("lastMergeDate", summary.LastMergeDate.String()).
+		Info("Finished parsing merged ids file")
+	return summary, nil
 }
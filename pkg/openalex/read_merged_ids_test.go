@@ -1,9 +1,21 @@
 package openalex
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 )
 
+// testMergeDate parses a merge date or fails the test
+func testMergeDate(t *testing.T, value string) MergeDate {
+	t.Helper()
+	mergeDate, err := ParseMergeDate(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mergeDate
+}
+
 func TestParseMergedIDsFile(t *testing.T) {
 
 	var tests = []struct {
@@ -17,11 +29,82 @@ func TestParseMergedIDsFile(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.data, func(t *testing.T) {
-			err := ParseMergedIDsFile(tt.path, PrintMergedIdRecordHandler)
+			summary, err := ParseMergedIDsFile(tt.path, PrintMergedIdRecordHandler)
 			if err != nil {
 				t.Error(err)
 			}
+			if summary.EntityType != AuthorsFileEntityType || summary.Handled != summary.Rows-summary.Invalid {
+				t.Error("unexpected summary", summary)
+			}
 		})
 	}
 
 }
+
+func TestParseMergedIDsFileSince(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "merged_ids", "works", "2023-05-01.csv")
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := "merge_date,id,merge_into_id\n" +
+		"2023-03-01,W1,W2\n" +
+		"2023-04-01,W3,W4\n" +
+		"2023-05-01,W5,W6\n" +
+		"not-a-date,W7,W8\n" +
+		"2023-05-01,W9\n"
+	if err := os.WriteFile(filePath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var handled []MergedID
+	summary, err := ParseMergedIDsFileSince(filePath, testMergeDate(t, "2023-03-31"), func(fileEntityType FileEntityType, mergedID MergedID) error {
+		handled = append(handled, mergedID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 2 || handled[0].ID != "W3" || handled[1].MergeDate.String() != "2023-05-01" {
+		t.Error("unexpected merged ids", handled)
+	}
+	if summary.EntityType != WorksFileEntityType || summary.Rows != 5 || summary.Invalid != 2 ||
+		summary.Skipped != 1 || summary.Handled != 2 ||
+		summary.FirstMergeDate.String() != "2023-03-01" || summary.LastMergeDate.String() != "2023-05-01" {
+		t.Error("unexpected summary", summary)
+	}
+
+	// a first row without header is not skipped
+	if err := os.WriteFile(filePath, []byte("2023-03-01,W1,W2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	summary, err = ParseMergedIDsFile(filePath, func(FileEntityType, MergedID) error { return nil })
+	if err != nil || summary.Handled != 1 {
+		t.Error("expected the first row to be handled", summary, err)
+	}
+}
+
+func TestGetEntityTypeOfID(t *testing.T) {
+	var tests = []struct {
+		id       string
+		expected FileEntityType
+	}{
+		{"W2741809807", WorksFileEntityType},
+		{"https://openalex.org/A5023888391", AuthorsFileEntityType},
+		{"S137773608", SourcesFileEntityType},
+		{"i27837315", InstitutionsFileEntityType},
+		{"C41008148", ConceptsFileEntityType},
+		{"P4310320990", PublishersFileEntityType},
+		{"F4320332161", FundersFileEntityType},
+		{"T10017", TopicsFileEntityType},
+	}
+	for _, tt := range tests {
+		entityType, err := GetEntityTypeOfID(tt.id)
+		if err != nil || entityType != tt.expected {
+			t.Error("unexpected entity type", tt.id, entityType, err)
+		}
+	}
+	for _, id := range []string{"", "X123", "Works"} {
+		if _, err := GetEntityTypeOfID(id); err == nil {
+			t.Error("expected an error", id)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package openalex
+
+import (
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrCacheMiss is returned in cache only mode when a response is not cached
+var ErrCacheMiss = errors.New("response not in cache")
+
+// CachedResponseSQL is a cached response of the REST API
+type CachedResponseSQL struct {
+	RequestKey string `gorm:"primaryKey"` // normalized request url without mailto and api_key
+	Body       []byte
+	CachedAt   time.Time
+}
+
+// ResponseCache stores the responses of the REST API in a SQLite database.
+// Only successful responses are cached.
+type ResponseCache struct {
+	DatabasePath string
+	TTL          time.Duration // maximal age of a cached response, 0 means the responses never expire
+	// CacheOnly disables all network requests, so results can be replayed offline.
+	// Expired responses are returned as well and missing responses result in ErrCacheMiss.
+	CacheOnly bool
+	mu        sync.Mutex
+	db        *gorm.DB
+}
+
+// NewResponseCache opens or creates the cache database
+func NewResponseCache(databasePath string, ttl time.Duration) (*ResponseCache, error) {
+	logger := slog.With("databasePath", databasePath)
+	db, err := gorm.Open(sqlite.Open(databasePath), &gorm.Config{})
+	if err != nil {
+		logger.With("err", err).Error("could not open response cache")
+		return nil, err
+	}
+	err = db.AutoMigrate(&CachedResponseSQL{})
+	if err != nil {
+		logger.With("err", err).Error("could not migrate response cache")
+		return nil, err
+	}
+	return &ResponseCache{
+		DatabasePath: databasePath,
+		TTL:          ttl,
+		db:           db,
+	}, nil
+}
+
+// Get returns the cached response of the key.
+// It returns false if the response is not cached or expired.
+func (rc *ResponseCache) Get(key string) (body []byte, ok bool, err error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	var response CachedResponseSQL
+	err = rc.db.Where("request_key = ?", key).First(&response).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		slog.With("err", err).With("key", key).Error("could not read cached response")
+		return nil, false, err
+	}
+	if rc.TTL > 0 && !rc.CacheOnly && time.Since(response.CachedAt) > rc.TTL {
+		return nil, false, nil
+	}
+	return response.Body, true, nil
+}
+
+// Put stores the response of the key and replaces an existing response
+func (rc *ResponseCache) Put(key string, body []byte) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	response := CachedResponseSQL{RequestKey: key, Body: body, CachedAt: time.Now()}
+	err := rc.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&response).Error
+	if err != nil {
+		slog.With("err", err).With("key", key).Error("could not cache response")
+	}
+	return err
+}
+
+// Clear removes all cached responses
+func (rc *ResponseCache) Clear() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.db.Where("1 = 1").Delete(&CachedResponseSQL{}).Error
+}
+
+// Close closes the database
+func (rc *ResponseCache) Close() error {
+	sqlDB, err := rc.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// cacheKey normalizes a request to a cache key.
+// The parameters are sorted and the credentials are removed, so the key does not depend on the mailto address or the api key.
+func cacheKey(baseUrl string, path string, values url.Values) string {
+	params := url.Values{}
+	for key, value := range values {
+		if key == "mailto" || key == "api_key" {
+			continue
+		}
+		params[key] = value
+	}
+	key := strings.TrimSuffix(baseUrl, "/") + "/" + strings.Trim(path, "/")
+	if len(params) > 0 {
+		key += "?" + params.Encode()
+	}
+	return key
+}
@@ -0,0 +1,86 @@
+package openalex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResponseCache(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"id":"https://openalex.org/W1"}`))
+	}))
+	defer server.Close()
+	cache, err := NewResponseCache(filepath.Join(t.TempDir(), "cache.db"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	client := NewClient("test@example.com")
+	client.BaseUrl = server.URL
+	client.RateLimit = 0
+	client.Cache = cache
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		work, errGet := client.GetWork(ctx, "W1")
+		if errGet != nil || work.ID != "https://openalex.org/W1" {
+			t.Fatal("unexpected work", work, errGet)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Error("expected a single request", calls.Load())
+	}
+
+	// the credentials are not part of the key
+	client.Mailto = "other@example.com"
+	client.ApiKey = "secret"
+	_, err = client.GetWork(ctx, "W1")
+	if err != nil || calls.Load() != 1 {
+		t.Error("expected a cached response", calls.Load(), err)
+	}
+
+	// expired responses are fetched again
+	cache.TTL = time.Nanosecond
+	_, err = client.GetWork(ctx, "W1")
+	if err != nil || calls.Load() != 2 {
+		t.Error("expected a new request", calls.Load(), err)
+	}
+
+	// cache only mode replays expired responses without network access
+	server.Close()
+	cache.CacheOnly = true
+	_, err = client.GetWork(ctx, "W1")
+	if err != nil {
+		t.Error("expected a cached response", err)
+	}
+	_, err = client.GetWork(ctx, "W2")
+	if !errors.Is(err, ErrCacheMiss) {
+		t.Error("expected a cache miss", err)
+	}
+
+	err = cache.Clear()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.GetWork(ctx, "W1")
+	if !errors.Is(err, ErrCacheMiss) {
+		t.Error("expected a cache miss after clearing", err)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	a := cacheKey("https://api.openalex.org/", "/works", url.Values{"filter": {"doi:x"}, "mailto": {"a@b.c"}, "per-page": {"50"}})
+	b := cacheKey("https://api.openalex.org", "works", url.Values{"per-page": {"50"}, "api_key": {"secret"}, "filter": {"doi:x"}})
+	if a != b || a != "https://api.openalex.org/works?filter=doi%3Ax&per-page=50" {
+		t.Error("unexpected keys", a, b)
+	}
+}
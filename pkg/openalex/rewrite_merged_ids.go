@@ -0,0 +1,188 @@
+package openalex
+
+import (
+	"strings"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// openAlexUrlPrefix is the prefix of the ids in the snapshot
+const openAlexUrlPrefix = "https://openalex.org/"
+
+// rewriteJson keeps numbers as they are and does not escape html, so that the values of the other fields do not change.
+// A rewritten line is decoded into a map, so its keys are sorted and only the last one of duplicate keys is kept.
+var rewriteJson = jsoniter.Config{
+	UseNumber:   true,
+	SortMapKeys: true,
+}.Froze()
+
+// DefaultRewritePaths are the paths of the references to other entities per entity type.
+// A path consists of the json keys separated by dots, "[]" iterates over an array.
+// The id of the entity itself is not rewritten.
+var DefaultRewritePaths = map[FileEntityType][]string{
+	WorksFileEntityType: {
+		"authorships[].author.id",
+		"authorships[].institutions[].id",
+		"authorships[].institutions[].lineage[]",
+		"corresponding_author_ids[]",
+		"corresponding_institution_ids[]",
+		"primary_location.source.id",
+		"primary_location.source.host_organization",
+		"best_oa_location.source.id",
+		"best_oa_location.source.host_organization",
+		"locations[].source.id",
+		"locations[].source.host_organization",
+		"grants[].funder",
+		"referenced_works[]",
+		"related_works[]",
+		"concepts[].id",
+	},
+	AuthorsFileEntityType: {
+		"last_known_institution.id",
+		"last_known_institutions[].id",
+		"affiliations[].institution.id",
+		"most_cited_work",
+		"x_concepts[].id",
+	},
+	SourcesFileEntityType: {
+		"host_organization",
+		"host_organization_lineage[]",
+		"x_concepts[].id",
+	},
+	InstitutionsFileEntityType: {
+		"associated_institutions[].id",
+		"lineage[]",
+		"repositories[].host_organization",
+		"repositories[].host_organization_lineage[]",
+		"x_concepts[].id",
+	},
+	PublishersFileEntityType: {
+		"lineage[]",
+		"parent_publisher",
+	},
+	ConceptsFileEntityType: {
+		"ancestors[].id",
+		"related_concepts[].id",
+	},
+}
+
+// IDRewriter rewrites references to merged entities with the ids they were merged into.
+// Unknown fields of the entities are preserved, but the keys of a rewritten line are sorted.
+type IDRewriter struct {
+	Resolver *MergedIDResolver
+	Paths    map[FileEntityType][]string // DefaultRewritePaths if nil
+	mu       sync.Mutex
+	counts   map[FileEntityType]int
+}
+
+// NewIDRewriter creates a rewriter that uses the resolver with the DefaultRewritePaths
+func NewIDRewriter(resolver *MergedIDResolver) *IDRewriter {
+	return &IDRewriter{
+		Resolver: resolver,
+		Paths:    DefaultRewritePaths,
+		counts:   make(map[FileEntityType]int),
+	}
+}
+
+// Counts returns the number of rewritten references per entity type
+func (rw *IDRewriter) Counts() map[FileEntityType]int {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	counts := make(map[FileEntityType]int, len(rw.counts))
+	for entityType, count := range rw.counts {
+		counts[entityType] = count
+	}
+	return counts
+}
+
+// RewriteLine rewrites the references of a json line of an entity type.
+// The line is returned unchanged if no reference was rewritten.
+// Otherwise the line is encoded again with the keys of all objects sorted, see rewriteJson.
+func (rw *IDRewriter) RewriteLine(entityType FileEntityType, line string) (result string, rewritten int, err error) {
+	paths := rw.Paths
+	if paths == nil {
+		paths = DefaultRewritePaths
+	}
+	if len(paths[entityType]) == 0 {
+		return line, 0, nil
+	}
+	var entity map[string]any
+	err = rewriteJson.UnmarshalFromString(line, &entity)
+	if err != nil {
+		return line, 0, err
+	}
+	for _, path := range paths[entityType] {
+		rewritten += rw.rewritePath(entity, strings.Split(path, "."))
+	}
+	if rewritten == 0 {
+		return line, 0, nil
+	}
+	result, err = rewriteJson.MarshalToString(entity)
+	if err != nil {
+		return line, 0, err
+	}
+	rw.mu.Lock()
+	if rw.counts == nil {
+		rw.counts = make(map[FileEntityType]int)
+	}
+	rw.counts[entityType] += rewritten
+	rw.mu.Unlock()
+	return result, rewritten, nil
+}
+
+// rewritePath rewrites the ids below the path of an object and returns the number of rewritten ids
+func (rw *IDRewriter) rewritePath(object map[string]any, path []string) (rewritten int) {
+	key, isArray := strings.CutSuffix(path[0], "[]")
+	value, ok := object[key]
+	if !ok || value == nil {
+		return 0
+	}
+	if !isArray {
+		if len(path) == 1 {
+			if id, ok := rw.resolve(value); ok {
+				object[key] = id
+				return 1
+			}
+			return 0
+		}
+		child, ok := value.(map[string]any)
+		if !ok {
+			return 0
+		}
+		return rw.rewritePath(child, path[1:])
+	}
+	items, ok := value.([]any)
+	if !ok {
+		return 0
+	}
+	for i, item := range items {
+		if len(path) == 1 {
+			if id, ok := rw.resolve(item); ok {
+				items[i] = id
+				rewritten++
+			}
+			continue
+		}
+		if child, ok := item.(map[string]any); ok {
+			rewritten += rw.rewritePath(child, path[1:])
+		}
+	}
+	return rewritten
+}
+
+// resolve returns the id a merged id was merged into, in the format of the original id
+func (rw *IDRewriter) resolve(value any) (string, bool) {
+	id, ok := value.(string)
+	if !ok || id == "" {
+		return "", false
+	}
+	canonicalID, _, ok := rw.Resolver.Resolve(id)
+	if !ok {
+		return "", false
+	}
+	if strings.HasPrefix(id, openAlexUrlPrefix) {
+		canonicalID = openAlexUrlPrefix + canonicalID
+	}
+	return canonicalID, true
+}
@@ -0,0 +1,91 @@
+package openalex
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIDRewriter(t *testing.T) {
+	resolver := NewMergedIDResolver()
+	resolver.Add(MergedID{MergeDate: testMergeDate(t, "2023-01-01"), ID: "A1", MergeIntoID: "A2"})
+	resolver.Add(MergedID{MergeDate: testMergeDate(t, "2023-01-01"), ID: "A2", MergeIntoID: "A3"})
+	resolver.Add(MergedID{MergeDate: testMergeDate(t, "2023-01-01"), ID: "I1", MergeIntoID: "I2"})
+	resolver.Add(MergedID{MergeDate: testMergeDate(t, "2023-01-01"), ID: "S1", MergeIntoID: "S2"})
+	resolver.Add(MergedID{MergeDate: testMergeDate(t, "2023-01-01"), ID: "W1", MergeIntoID: "W2"})
+	rw := NewIDRewriter(resolver)
+
+	line := `{"id":"https://openalex.org/W9","unknown_field":{"nested":[1,2.50,12345678901234567890]},` +
+		`"authorships":[{"author":{"id":"https://openalex.org/A1"},"institutions":[{"id":"https://openalex.org/I1"},{"id":"https://openalex.org/I5"}]}],` +
+		`"primary_location":{"source":{"id":"https://openalex.org/S1"}},"best_oa_location":null,` +
+		`"referenced_works":["https://openalex.org/W1","https://openalex.org/W3"],"related_works":["W1"],"title":"<b>Title</b>"}`
+	result, rewritten, err := rw.RewriteLine(WorksFileEntityType, line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewritten != 5 {
+		t.Error("expected 5 rewritten references", rewritten, result)
+	}
+	for _, expected := range []string{
+		`"author":{"id":"https://openalex.org/A3"}`,
+		`{"id":"https://openalex.org/I2"},{"id":"https://openalex.org/I5"}`,
+		`"source":{"id":"https://openalex.org/S2"}`,
+		`"referenced_works":["https://openalex.org/W2","https://openalex.org/W3"]`,
+		`"related_works":["W2"]`,
+		`"id":"https://openalex.org/W9"`,
+		`"unknown_field":{"nested":[1,2.50,12345678901234567890]}`,
+		`"title":"<b>Title</b>"`,
+	} {
+		if !strings.Contains(result, expected) {
+			t.Error("missing", expected, result)
+		}
+	}
+
+	// lines without merged references are not changed
+	unchanged := `{"id":"https://openalex.org/W3","related_works":["W3"],"b":1,"a":2}`
+	result, rewritten, err = rw.RewriteLine(WorksFileEntityType, unchanged)
+	if err != nil || rewritten != 0 || result != unchanged {
+		t.Error("expected an unchanged line", result, rewritten, err)
+	}
+
+	result, _, err = rw.RewriteLine(AuthorsFileEntityType, `{"id":"A9","last_known_institution":{"id":"I1"},"affiliations":[{"institution":{"id":"I1"}}]}`)
+	if err != nil || strings.Contains(result, `"I1"`) {
+		t.Error("expected rewritten institutions", result, err)
+	}
+	counts := rw.Counts()
+	if counts[WorksFileEntityType] != 5 || counts[AuthorsFileEntityType] != 2 {
+		t.Error("unexpected counts", counts)
+	}
+}
+
+func TestProcessorIDRewriter(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "works", "updated_date=2023-05-01", "part_000.gz")
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, gzipBytes(t, `{"id":"W5","referenced_works":["W1"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resolver := NewMergedIDResolver()
+	resolver.Add(MergedID{MergeDate: testMergeDate(t, "2023-01-01"), ID: "W1", MergeIntoID: "W2"})
+	var lines []string
+	p := Processor{
+		DirectoryPath: dir,
+		IDRewriter:    NewIDRewriter(resolver),
+		LineHandler: func(filePath string, line string) error {
+			lines = append(lines, line)
+			return nil
+		},
+	}
+	if _, err := p.ProcessDirectory(); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || !strings.Contains(lines[0], `"referenced_works":["W2"]`) {
+		t.Error("unexpected lines", lines)
+	}
+	if p.IDRewriter.Counts()[WorksFileEntityType] != 1 {
+		t.Error("unexpected counts", p.IDRewriter.Counts())
+	}
+}
@@ -0,0 +1,238 @@
+package openalex
+
+import (
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FileReport is the result of a processed file
+type FileReport struct {
+	FilePath          string         `json:"file_path"`
+	EntityType        FileEntityType `json:"entity_type"`
+	Lines             int            `json:"lines"`              // handled lines, or handled merges of a merged ids file
+	SkippedLines      int            `json:"skipped_lines"`      // lines skipped by the error policy, or invalid and filtered merges
+	CompressedBytes   int64          `json:"compressed_bytes"`   // size of the file
+	UncompressedBytes int64          `json:"uncompressed_bytes"` // size of the read lines, 0 for merged ids files
+	Skipped           bool           `json:"skipped"`            // the file was finished in a previous run
+	Duration          time.Duration  `json:"duration"`
+	LinesPerSecond    float64        `json:"lines_per_second"`
+	BytesPerSecond    float64        `json:"bytes_per_second"` // uncompressed
+	Error             string         `json:"error,omitempty"`
+}
+
+// EntityRunReport sums up the files of an entity type
+type EntityRunReport struct {
+	EntityType        FileEntityType `json:"entity_type"`
+	Files             int            `json:"files"`
+	FailedFiles       int            `json:"failed_files"`
+	Lines             int            `json:"lines"`
+	SkippedLines      int            `json:"skipped_lines"`
+	CompressedBytes   int64          `json:"compressed_bytes"`
+	UncompressedBytes int64          `json:"uncompressed_bytes"`
+	Duration          time.Duration  `json:"duration"`         // sum of the durations of the files
+	LinesPerSecond    float64        `json:"lines_per_second"` // lines per second of a single worker
+}
+
+// RunReport is the result of a Processor run
+type RunReport struct {
+	StartedAt         time.Time          `json:"started_at"`
+	Duration          time.Duration      `json:"duration"`
+	Files             []*FileReport      `json:"files"` // the started files in the order of the input
+	Entities          []*EntityRunReport `json:"entities"`
+	FailedFiles       int                `json:"failed_files"`
+	Lines             int                `json:"lines"`
+	SkippedLines      int                `json:"skipped_lines"`
+	CompressedBytes   int64              `json:"compressed_bytes"`
+	UncompressedBytes int64              `json:"uncompressed_bytes"`
+	LinesPerSecond    float64            `json:"lines_per_second"`
+	BytesPerSecond    float64            `json:"bytes_per_second"` // uncompressed
+}
+
+// finish sums up the files and calculates the throughput
+func (r *RunReport) finish() {
+	r.Duration = time.Since(r.StartedAt)
+	index := make(map[FileEntityType]*EntityRunReport)
+	for _, f := range r.Files {
+		if f.Duration > 0 {
+			f.LinesPerSecond = float64(f.Lines) / f.Duration.Seconds()
+			f.BytesPerSecond = float64(f.UncompressedBytes) / f.Duration.Seconds()
+		}
+		e, ok := index[f.EntityType]
+		if !ok {
+			e = &EntityRunReport{EntityType: f.EntityType}
+			index[f.EntityType] = e
+			r.Entities = append(r.Entities, e)
+		}
+		e.Files++
+		e.Lines += f.Lines
+		e.SkippedLines += f.SkippedLines
+		e.CompressedBytes += f.CompressedBytes
+		e.UncompressedBytes += f.UncompressedBytes
+		e.Duration += f.Duration
+		if f.Error != "" {
+			e.FailedFiles++
+			r.FailedFiles++
+		}
+		r.Lines += f.Lines
+		r.SkippedLines += f.SkippedLines
+		r.CompressedBytes += f.CompressedBytes
+		r.UncompressedBytes += f.UncompressedBytes
+	}
+	for _, e := range r.Entities {
+		if e.Duration > 0 {
+			e.LinesPerSecond = float64(e.Lines) / e.Duration.Seconds()
+		}
+	}
+	if r.Duration > 0 {
+		r.LinesPerSecond = float64(r.Lines) / r.Duration.Seconds()
+		r.BytesPerSecond = float64(r.UncompressedBytes) / r.Duration.Seconds()
+	}
+}
+
+// Progress is a live progress event of a Processor run
+type Progress struct {
+	FilePath          string        // the finished file, empty for periodic events
+	FilesDone         int           // finished, failed and skipped files
+	FilesTotal        int           // files of the run
+	Lines             int64         // lines read in the run, including the lines of files finished in a previous run
+	ExpectedLines     int64         // record count of the files in the manifests, 0 if unknown
+	UncompressedBytes int64         // bytes read in the run
+	Elapsed           time.Duration // time since the start of the run
+	ETA               time.Duration // estimated remaining time, 0 if unknown
+}
+
+// Percent returns the progress in percent of the expected lines, or of the files if the record counts are unknown
+func (pr Progress) Percent() float64 {
+	if pr.ExpectedLines > 0 {
+		return min(float64(pr.Lines)/float64(pr.ExpectedLines)*100, 100)
+	}
+	if pr.FilesTotal > 0 {
+		return float64(pr.FilesDone) / float64(pr.FilesTotal) * 100
+	}
+	return 0
+}
+
+// ProgressHandler receives the live progress of a run, the calls are not concurrent
+type ProgressHandler func(progress Progress)
+
+// DefaultProgressInterval is the interval of the periodic progress events
+const DefaultProgressInterval = 10 * time.Second
+
+// runProgress tracks the progress of a run. All methods can be called on nil.
+type runProgress struct {
+	handler       ProgressHandler
+	startedAt     time.Time
+	filesTotal    int
+	expectedLines int64
+	expected      map[string]int64 // record counts by file path
+	filesDone     atomic.Int64
+	lines         atomic.Int64
+	bytes         atomic.Int64
+	mu            sync.Mutex
+}
+
+// newRunProgress creates the progress of the files, the expected lines are taken from the manifests of the directory
+func (p *Processor) newRunProgress(filePaths []string) *runProgress {
+	if p.ProgressHandler == nil {
+		return nil
+	}
+	rp := &runProgress{
+		handler:    p.ProgressHandler,
+		startedAt:  time.Now(),
+		filesTotal: len(filePaths),
+		expected:   make(map[string]int64),
+	}
+	if p.DirectoryPath == "" {
+		return rp
+	}
+	manifests, err := ReadManifestsFromDirectory(p.DirectoryPath)
+	if err != nil {
+		slog.With("err", err).Warn("Progress without record counts")
+		return rp
+	}
+	recordCounts := make(map[string]int64)
+	for _, manifest := range manifests {
+		for _, entry := range manifest.Entries {
+			recordCounts[filepath.Clean(entry.LocalPath(p.DirectoryPath))] = int64(entry.Meta.RecordCount)
+		}
+	}
+	for _, filePath := range filePaths {
+		if count, ok := recordCounts[filepath.Clean(filePath)]; ok {
+			rp.expected[filePath] = count
+			rp.expectedLines += count
+		}
+	}
+	return rp
+}
+
+// addLine counts a read line
+func (rp *runProgress) addLine(bytes int64) {
+	if rp == nil {
+		return
+	}
+	rp.lines.Add(1)
+	rp.bytes.Add(bytes)
+}
+
+// addFinishedFile counts the lines of a file that was finished in a previous run
+func (rp *runProgress) addFinishedFile(filePath string) {
+	if rp == nil {
+		return
+	}
+	rp.lines.Add(rp.expected[filePath])
+}
+
+// fileDone counts a file and sends an event
+func (rp *runProgress) fileDone(filePath string) {
+	if rp == nil {
+		return
+	}
+	rp.filesDone.Add(1)
+	rp.emit(filePath)
+}
+
+// emit sends the current progress to the handler
+func (rp *runProgress) emit(filePath string) {
+	if rp == nil {
+		return
+	}
+	progress := Progress{
+		FilePath:          filePath,
+		FilesDone:         int(rp.filesDone.Load()),
+		FilesTotal:        rp.filesTotal,
+		Lines:             rp.lines.Load(),
+		ExpectedLines:     rp.expectedLines,
+		UncompressedBytes: rp.bytes.Load(),
+		Elapsed:           time.Since(rp.startedAt),
+	}
+	if progress.ExpectedLines > 0 && progress.Lines > 0 && progress.Lines < progress.ExpectedLines {
+		remaining := float64(progress.ExpectedLines-progress.Lines) / float64(progress.Lines)
+		progress.ETA = time.Duration(float64(progress.Elapsed) * remaining)
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.handler(progress)
+}
+
+// run sends periodic events until stop is closed
+func (rp *runProgress) run(interval time.Duration, stop <-chan struct{}) {
+	if rp == nil {
+		return
+	}
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			rp.emit("")
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package openalex
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProcessorRunReport(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"works/manifest": []byte(`{"entries": [
+			{"url": "s3://openalex/data/works/updated_date=2023-05-01/part_000.gz", "meta": {"content_length": 1, "record_count": 3}},
+			{"url": "s3://openalex/data/works/updated_date=2023-05-02/part_000.gz", "meta": {"content_length": 1, "record_count": 1}}
+		]}`),
+		"authors/manifest": []byte(`{"entries": [
+			{"url": "s3://openalex/data/authors/updated_date=2023-05-01/part_000.gz", "meta": {"content_length": 1, "record_count": 4}}
+		]}`),
+		"works/updated_date=2023-05-01/part_000.gz":   gzipBytes(t, `{"id":"W1"}`, `{"id":"W2"}`, `{"id":"W3"}`),
+		"works/updated_date=2023-05-02/part_000.gz":   gzipBytes(t, `{"id":"W4"}`),
+		"authors/updated_date=2023-05-01/part_000.gz": gzipBytes(t, `{"id":"A1"}`, `{"id":"A2"}`, `{"id":"A3"}`, `{"id":"A4"}`),
+		"merged_ids/works/2023-05-01.csv.gz":          gzipBytes(t, "merge_date,id,merge_into_id", "2023-05-01,W5,W1", "invalid"),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var events []Progress
+	p := Processor{
+		DirectoryPath:    dir,
+		UseManifests:     true,
+		Workers:          2,
+		LineHandler:      func(filePath string, line string) error { return nil },
+		MergedIdHandler:  func(fileEntityType FileEntityType, mergedID MergedID) error { return nil },
+		ProgressHandler:  func(progress Progress) { events = append(events, progress) },
+		ProgressInterval: time.Hour,
+	}
+	report, err := p.ProcessDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Files) != 4 || report.Lines != 9 || report.SkippedLines != 1 || report.FailedFiles != 0 {
+		t.Fatal("unexpected report", report)
+	}
+	entities := make(map[FileEntityType]*EntityRunReport)
+	for _, e := range report.Entities {
+		entities[e.EntityType] = e
+	}
+	if entities[WorksFileEntityType].Files != 3 || entities[WorksFileEntityType].Lines != 5 ||
+		entities[AuthorsFileEntityType].Lines != 4 {
+		t.Error("unexpected entity reports", entities[WorksFileEntityType], entities[AuthorsFileEntityType])
+	}
+	for _, f := range report.Files {
+		if f.FilePath == filepath.Join(dir, "works", "updated_date=2023-05-01", "part_000.gz") &&
+			(f.EntityType != WorksFileEntityType || f.Lines != 3 || f.UncompressedBytes != 3*12 || f.CompressedBytes == 0 ||
+				f.LinesPerSecond <= 0 || f.BytesPerSecond <= 0) {
+			t.Error("unexpected file report", f)
+		}
+	}
+	if merged := report.Files[len(report.Files)-1]; merged.Lines != 1 || merged.SkippedLines != 1 {
+		t.Error("unexpected merged ids report", merged)
+	}
+	if report.UncompressedBytes != 8*12 || report.Duration <= 0 {
+		t.Error("unexpected totals", report.UncompressedBytes, report.Duration)
+	}
+
+	if len(events) != 4 {
+		t.Fatal("expected an event per file", events)
+	}
+	last := events[len(events)-1]
+	if last.FilesDone != 4 || last.FilesTotal != 4 || last.ExpectedLines != 8 || last.Lines != 8 || last.Percent() != 100 || last.ETA != 0 {
+		t.Error("unexpected last event", last)
+	}
+	for _, event := range events[:2] {
+		if event.Lines < 8 && event.Lines > 0 && event.ETA <= 0 {
+			t.Error("expected an eta", event)
+		}
+	}
+}
@@ -131,6 +131,20 @@ func (sh *StateHandler) RegisterOrSkipEntityFile(filePath string) (bool, error)
 	return entityFile.Done, nil
 }
 
+// IsEntityFileFinished returns true if the entity file is registered and marked as finished
+// In contrast to RegisterOrSkipEntityFile it does not create an entry
+func (sh *StateHandler) IsEntityFileFinished(filePath string) (bool, error) {
+	var entityFile EntityFileSQL
+	err := sh.db.Where("identifier = ?", filePath).First(&entityFile).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return entityFile.Done, nil
+}
+
 func (sh *StateHandler) RegisterOrSkipEntityLine(line_info string) (bool, error) {
 	identifier := sh.currentEntityFileSQL.Identifier + "::" + line_info
 
@@ -0,0 +1,378 @@
+package openalex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+)
+
+// PlannedFile is a file that is downloaded or deleted by a sync
+type PlannedFile struct {
+	Key       string `json:"key"`
+	LocalPath string `json:"local_path"`
+	Bytes     int64  `json:"bytes"`
+	Records   int    `json:"records"` // from the manifest, 0 for merged ids files
+}
+
+// PlannedPartition is a partition that is added, replaced or deleted by a sync
+type PlannedPartition struct {
+	UpdatedDate string        `json:"updated_date"` // e.g. 2023-05-16, the merge date for merged ids files
+	MergedIds   bool          `json:"merged_ids"`
+	Bytes       int64         `json:"bytes"`
+	Records     int           `json:"records"`
+	Files       []PlannedFile `json:"files"`
+}
+
+// EntitySyncPlan contains the planned changes of an entity type
+type EntitySyncPlan struct {
+	EntityType      FileEntityType     `json:"entity_type"`
+	Add             []PlannedPartition `json:"add"`     // partitions that do not exist locally
+	Replace         []PlannedPartition `json:"replace"` // partitions that exist locally, but have new or changed files
+	Delete          []PlannedPartition `json:"delete"`  // local partitions that are no longer part of the snapshot
+	DownloadBytes   int64              `json:"download_bytes"`
+	DownloadRecords int                `json:"download_records"`
+	DeleteBytes     int64              `json:"delete_bytes"`
+	DeleteRecords   int                `json:"delete_records"`
+	// the manifest is written after the part files were downloaded,
+	// it only lists the files that are included by the sync options
+	manifestKey   string
+	manifestBytes []byte
+}
+
+// SyncPlan describes the changes a sync would make to the local directory
+type SyncPlan struct {
+	Entities        []*EntitySyncPlan `json:"entities"`
+	DownloadBytes   int64             `json:"download_bytes"`
+	DownloadRecords int               `json:"download_records"`
+	DeleteBytes     int64             `json:"delete_bytes"`
+	DeleteRecords   int               `json:"delete_records"`
+}
+
+// Plan compares the local directory with the remote manifests without changing anything.
+// The plan can be executed with Apply.
+func (d *SnapshotDownloader) Plan(ctx context.Context) (plan *SyncPlan, err error) {
+	logger := slog.With("destPath", d.DestPath, "baseUrl", d.BaseUrl)
+	plan = &SyncPlan{}
+	err = d.Options.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, manifestUrl := range AllManifestUrls {
+		entityType, errType := GetEntityType(manifestUrl.Key())
+		if errType != nil {
+			return nil, errType
+		}
+		if !d.Options.IncludesEntityType(entityType) {
+			continue
+		}
+		entityPlan, errPlan := d.planEntity(ctx, entityType, manifestUrl)
+		if errPlan != nil {
+			logger.With("err", errPlan).With("manifestUrl", manifestUrl).Error("error while planning entity")
+			return nil, errPlan
+		}
+		plan.Entities = append(plan.Entities, entityPlan)
+	}
+
+	if !d.Options.SkipMergedIds {
+		err = d.planMergedIds(ctx, plan)
+		if err != nil {
+			logger.With("err", err).Error("error while planning merged ids")
+			return nil, err
+		}
+	}
+
+	for _, entityPlan := range plan.Entities {
+		entityPlan.summarize()
+		plan.DownloadBytes += entityPlan.DownloadBytes
+		plan.DownloadRecords += entityPlan.DownloadRecords
+		plan.DeleteBytes += entityPlan.DeleteBytes
+		plan.DeleteRecords += entityPlan.DeleteRecords
+	}
+	return plan, nil
+}
+
+// planEntity compares the local partitions of an entity with its remote manifest
+func (d *SnapshotDownloader) planEntity(ctx context.Context, entityType FileEntityType, manifestUrl ManifestUrl) (entityPlan *EntitySyncPlan, err error) {
+	entityPlan = &EntitySyncPlan{
+		EntityType:  entityType,
+		manifestKey: manifestUrl.Key(),
+	}
+	// fetch the manifest
+	remoteManifestBytes, err := d.fetch(ctx, manifestUrl.Key())
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	err = json.Unmarshal(remoteManifestBytes, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	entityPlan.manifestBytes, err = d.localManifest(entityType, &manifest, remoteManifestBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// files to download
+	expected := make(map[string]struct{}, len(manifest.Entries))
+	var download []PlannedFile
+	for _, entry := range manifest.Entries {
+		key := entry.Key()
+		localPath := d.localPath(key)
+		expected[localPath] = struct{}{}
+		if !d.Options.includesFile(entityType, localPath) {
+			continue
+		}
+		if d.isUpToDate(localPath, int64(entry.Meta.ContentLength)) {
+			continue
+		}
+		download = append(download, PlannedFile{
+			Key:       key,
+			LocalPath: localPath,
+			Bytes:     int64(entry.Meta.ContentLength),
+			Records:   entry.Meta.RecordCount,
+		})
+	}
+	for _, partition := range groupPlannedFiles(false, download) {
+		// a partition is replaced if its directory already contains files, unfinished downloads do not count
+		if hasFinishedFiles(filepath.Dir(partition.Files[0].LocalPath)) {
+			entityPlan.Replace = append(entityPlan.Replace, partition)
+		} else {
+			entityPlan.Add = append(entityPlan.Add, partition)
+		}
+	}
+
+	// files to delete, the record counts are taken from the previous local manifest
+	entityDir := filepath.Dir(d.localPath(manifestUrl.Key()))
+	outdated, err := findOutdatedFiles(entityDir, expected, func(path string) bool {
+		return getUpdatedDate(path) != ""
+	})
+	if err != nil {
+		return nil, err
+	}
+	localRecords := make(map[string]int)
+	localManifestPath := d.localPath(manifestUrl.Key())
+	if _, errStat := os.Stat(localManifestPath); errStat == nil {
+		localManifest, errRead := ReadManifestFromFile(localManifestPath)
+		if errRead == nil {
+			for _, entry := range localManifest.Entries {
+				localRecords[d.localPath(entry.Key())] = entry.Meta.RecordCount
+			}
+		}
+	}
+	entityPlan.Delete = groupPlannedFiles(false, d.plannedLocalFiles(outdated, localRecords))
+	return entityPlan, nil
+}
+
+// localManifest returns the manifest that is written to the local directory.
+// It only lists the files that are included by the sync options, so that a partial mirror
+// can be processed and verified with its manifest. Without a filter the remote manifest is kept as it is.
+func (d *SnapshotDownloader) localManifest(entityType FileEntityType, manifest *Manifest, remoteManifestBytes []byte) ([]byte, error) {
+	var local Manifest
+	for _, entry := range manifest.Entries {
+		if !d.Options.includesFile(entityType, d.localPath(entry.Key())) {
+			continue
+		}
+		local.Entries = append(local.Entries, entry)
+		local.Meta.ContentLength += int64(entry.Meta.ContentLength)
+		local.Meta.RecordCount += entry.Meta.RecordCount
+	}
+	if len(local.Entries) == len(manifest.Entries) {
+		return remoteManifestBytes, nil
+	}
+	if local.Entries == nil {
+		local.Entries = []ManifestEntry{}
+	}
+	return json.Marshal(&local)
+}
+
+// planMergedIds compares the local merged ids files with the bucket listing
+func (d *SnapshotDownloader) planMergedIds(ctx context.Context, plan *SyncPlan) (err error) {
+	objects, err := d.listBucket(ctx, mergedIdsPrefix)
+	if err != nil {
+		return err
+	}
+	expected := make(map[string]struct{}, len(objects))
+	for _, object := range objects {
+		entityType, errType := GetEntityType(object.Key)
+		if errType != nil {
+			slog.With("key", object.Key).Warn("Skipping merged ids file of unsupported entity type")
+			continue
+		}
+		if !d.Options.IncludesEntityType(entityType) {
+			continue
+		}
+		localPath := d.localPath(object.Key)
+		expected[localPath] = struct{}{}
+		if d.isUpToDate(localPath, object.Size) {
+			continue
+		}
+		partition := groupPlannedFiles(true, []PlannedFile{{Key: object.Key, LocalPath: localPath, Bytes: object.Size}})[0]
+		entityPlan := plan.entity(entityType)
+		if _, errStat := os.Stat(localPath); errStat == nil {
+			entityPlan.Replace = append(entityPlan.Replace, partition)
+		} else {
+			entityPlan.Add = append(entityPlan.Add, partition)
+		}
+	}
+
+	// only delete the merged ids files of the selected entity types
+	outdated, err := findOutdatedFiles(d.localPath(mergedIdsPrefix), expected, func(path string) bool {
+		entityType, errType := d.mergedIdsEntityType(path)
+		return errType == nil && d.Options.IncludesEntityType(entityType)
+	})
+	if err != nil {
+		return err
+	}
+	for _, file := range d.plannedLocalFiles(outdated, nil) {
+		entityType, _ := d.mergedIdsEntityType(file.LocalPath)
+		entityPlan := plan.entity(entityType)
+		entityPlan.Delete = append(entityPlan.Delete, groupPlannedFiles(true, []PlannedFile{file})...)
+	}
+	return nil
+}
+
+// plannedLocalFiles converts local files into planned files
+func (d *SnapshotDownloader) plannedLocalFiles(localPaths []string, records map[string]int) (files []PlannedFile) {
+	for _, localPath := range localPaths {
+		file := PlannedFile{
+			LocalPath: localPath,
+			Records:   records[localPath],
+		}
+		if relPath, err := filepath.Rel(d.DestPath, localPath); err == nil {
+			file.Key = filepath.ToSlash(relPath)
+		}
+		if info, err := os.Stat(localPath); err == nil {
+			file.Bytes = info.Size()
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// entity returns the plan of the entity type and creates it if it does not exist
+func (p *SyncPlan) entity(entityType FileEntityType) *EntitySyncPlan {
+	for _, entityPlan := range p.Entities {
+		if entityPlan.EntityType == entityType {
+			return entityPlan
+		}
+	}
+	entityPlan := &EntitySyncPlan{EntityType: entityType}
+	p.Entities = append(p.Entities, entityPlan)
+	return entityPlan
+}
+
+// summarize sums up the bytes and records of the partitions
+func (p *EntitySyncPlan) summarize() {
+	p.DownloadBytes, p.DownloadRecords, p.DeleteBytes, p.DeleteRecords = 0, 0, 0, 0
+	for _, partition := range append(p.Add, p.Replace...) {
+		p.DownloadBytes += partition.Bytes
+		p.DownloadRecords += partition.Records
+	}
+	for _, partition := range p.Delete {
+		p.DeleteBytes += partition.Bytes
+		p.DeleteRecords += partition.Records
+	}
+}
+
+// IsEmpty returns true if the sync would not change anything
+func (p *SyncPlan) IsEmpty() bool {
+	for _, entityPlan := range p.Entities {
+		if len(entityPlan.Add)+len(entityPlan.Replace)+len(entityPlan.Delete) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Print writes a human-readable summary of the plan
+func (p *SyncPlan) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "entity\tadd\treplace\tdelete\tdownload bytes\tdownload records\tdelete bytes\tdelete records\t")
+	for _, e := range p.Entities {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\t%s\t%s\t\n",
+			e.EntityType, len(e.Add), len(e.Replace), len(e.Delete),
+			humanize.Bytes(uint64(e.DownloadBytes)), humanize.Comma(int64(e.DownloadRecords)),
+			humanize.Bytes(uint64(e.DeleteBytes)), humanize.Comma(int64(e.DeleteRecords)))
+	}
+	fmt.Fprintf(tw, "total\t\t\t\t%s\t%s\t%s\t%s\t\n",
+		humanize.Bytes(uint64(p.DownloadBytes)), humanize.Comma(int64(p.DownloadRecords)),
+		humanize.Bytes(uint64(p.DeleteBytes)), humanize.Comma(int64(p.DeleteRecords)))
+	return tw.Flush()
+}
+
+// groupPlannedFiles groups the files by their partition, the order of the files is preserved
+func groupPlannedFiles(mergedIds bool, files []PlannedFile) (partitions []PlannedPartition) {
+	index := make(map[string]int)
+	for _, file := range files {
+		updatedDate := partitionDate(mergedIds, file.LocalPath)
+		key := filepath.Dir(file.LocalPath) + "::" + updatedDate
+		i, ok := index[key]
+		if !ok {
+			i = len(partitions)
+			index[key] = i
+			partitions = append(partitions, PlannedPartition{
+				UpdatedDate: updatedDate,
+				MergedIds:   mergedIds,
+			})
+		}
+		partitions[i].Files = append(partitions[i].Files, file)
+		partitions[i].Bytes += file.Bytes
+		partitions[i].Records += file.Records
+	}
+	return partitions
+}
+
+// partitionDate returns the updated date of a part file or the merge date of a merged ids file
+func partitionDate(mergedIds bool, filePath string) string {
+	if mergedIds {
+		// merged ids files are named after the merge date, e.g. 2023-04-13.csv.gz
+		date, _, _ := strings.Cut(filepath.Base(filePath), ".")
+		return date
+	}
+	return strings.TrimPrefix(getUpdatedDate(filePath), "updated_date=")
+}
+
+// hasFinishedFiles checks if the directory contains files that are not unfinished downloads
+func hasFinishedFiles(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && !isPartialPath(entry.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+// findOutdatedFiles returns all files below dir that are managed (according to isManaged)
+// but not contained in expected. The temporary files of unfinished downloads are never outdated.
+func findOutdatedFiles(dir string, expected map[string]struct{}, isManaged func(path string) bool) (outdated []string, err error) {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		// unfinished downloads are resumed, not deleted
+		if info.IsDir() || isPartialPath(path) || !isManaged(path) {
+			return nil
+		}
+		if _, ok := expected[path]; !ok {
+			outdated = append(outdated, path)
+		}
+		return nil
+	})
+	return outdated, err
+}
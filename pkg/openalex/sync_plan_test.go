@@ -0,0 +1,91 @@
+package openalex
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotDownloaderPlan(t *testing.T) {
+	objects := map[string][]byte{
+		"data/works/updated_date=2023-05-16/part_000.gz": gzipBytes(t, `{"id":"W1"}`, `{"id":"W2"}`),
+		"data/works/updated_date=2023-06-01/part_000.gz": gzipBytes(t, `{"id":"W3"}`),
+		"data/merged_ids/works/2023-04-13.csv.gz":        gzipBytes(t, "merge_date,id,merge_into_id"),
+	}
+	server := newTestBucket(t, objects)
+	destPath := t.TempDir()
+	d := NewSnapshotDownloader(destPath)
+	d.BaseUrl = server.URL
+	err := d.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the bucket changes
+	replaced := gzipBytes(t, `{"id":"W1"}`, `{"id":"W2"}`, `{"id":"W4"}`)
+	added := gzipBytes(t, `{"id":"W5"}`)
+	objects["data/works/updated_date=2023-05-16/part_000.gz"] = replaced
+	objects["data/works/updated_date=2023-07-01/part_000.gz"] = added
+	delete(objects, "data/works/updated_date=2023-06-01/part_000.gz")
+
+	// an unfinished download of the added partition is resumed, not deleted
+	partialDir := filepath.Join(destPath, "data", "works", "updated_date=2023-07-01")
+	if err = os.MkdirAll(partialDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(partialDir, "part_000.partial"), added[:5], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := d.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	works := plan.entity(WorksFileEntityType)
+	if len(works.Add) != 1 || works.Add[0].UpdatedDate != "2023-07-01" {
+		t.Error("unexpected add", works.Add)
+	}
+	if len(works.Replace) != 1 || works.Replace[0].UpdatedDate != "2023-05-16" {
+		t.Error("unexpected replace", works.Replace)
+	}
+	if len(works.Delete) != 1 || works.Delete[0].UpdatedDate != "2023-06-01" || works.Delete[0].Records != 1 {
+		t.Error("unexpected delete", works.Delete)
+	}
+	if plan.DownloadBytes != int64(len(replaced)+len(added)) || plan.DownloadRecords != 4 {
+		t.Error("unexpected download totals", plan.DownloadBytes, plan.DownloadRecords)
+	}
+	// the plan does not change the local directory
+	if _, err = os.Stat(filepath.Join(destPath, "data", "works", "updated_date=2023-06-01", "part_000.gz")); err != nil {
+		t.Error("plan must not delete files", err)
+	}
+
+	var buf bytes.Buffer
+	err = plan.Print(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), string(WorksFileEntityType)) {
+		t.Error("works missing in the printed plan", buf.String())
+	}
+
+	result, err := d.Apply(context.Background(), plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Added) != 2 || len(result.Removed) != 1 {
+		t.Error("unexpected result", result)
+	}
+	if data, errRead := os.ReadFile(filepath.Join(partialDir, "part_000.gz")); errRead != nil || !bytes.Equal(data, added) {
+		t.Error("expected the resumed download", errRead)
+	}
+	plan, err = d.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.IsEmpty() {
+		t.Error("expected an empty plan after apply")
+	}
+}
@@ -0,0 +1,141 @@
+package openalex
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// TypedHandlers are callbacks per entity type that receive the decoded entities.
+// Lines of entity types without a callback are passed to OnEntity, or skipped without decoding if OnEntity is nil.
+type TypedHandlers struct {
+	OnWork        func(ctx context.Context, work *Work) error
+	OnAuthor      func(ctx context.Context, author *Author) error
+	OnInstitution func(ctx context.Context, institution *Institution) error
+	OnSource      func(ctx context.Context, source *Source) error
+	OnConcept     func(ctx context.Context, concept *Concept) error
+	OnFunder      func(ctx context.Context, funder *Funder) error
+	OnPublisher   func(ctx context.Context, publisher *Publisher) error
+	OnTopic       func(ctx context.Context, topic *Topic) error
+	OnDomain      func(ctx context.Context, domain *Domain) error
+	// OnEntity is called for the entity types without a callback
+	OnEntity func(ctx context.Context, fileEntityType FileEntityType, entity Entity) error
+}
+
+// LineHandler returns a LineHandler that decodes the lines into the struct of the entity type of the file
+// and passes them to the callbacks with the context. It is safe for concurrent use if the callbacks are.
+func (h *TypedHandlers) LineHandler(ctx context.Context) LineHandler {
+	var entityTypes sync.Map // by file path
+	return func(filePath string, line string) error {
+		value, ok := entityTypes.Load(filePath)
+		if !ok {
+			entityType, err := GetEntityType(filePath)
+			if err != nil {
+				return err
+			}
+			value, _ = entityTypes.LoadOrStore(filePath, entityType)
+		}
+		return h.Handle(ctx, value.(FileEntityType), line)
+	}
+}
+
+// Handle decodes a json line of the entity type and passes it to its callback
+func (h *TypedHandlers) Handle(ctx context.Context, entityType FileEntityType, line string) (err error) {
+	switch {
+	case entityType == WorksFileEntityType && h.OnWork != nil:
+		return decodeAndHandle(ctx, line, func(ctx context.Context, work *Work) error {
+			return h.OnWork(ctx, work.GenerateAbstractFromInvertedIndex())
+		})
+	case entityType == AuthorsFileEntityType && h.OnAuthor != nil:
+		return decodeAndHandle(ctx, line, h.OnAuthor)
+	case entityType == InstitutionsFileEntityType && h.OnInstitution != nil:
+		return decodeAndHandle(ctx, line, h.OnInstitution)
+	case entityType == SourcesFileEntityType && h.OnSource != nil:
+		return decodeAndHandle(ctx, line, h.OnSource)
+	case entityType == ConceptsFileEntityType && h.OnConcept != nil:
+		return decodeAndHandle(ctx, line, h.OnConcept)
+	case entityType == FundersFileEntityType && h.OnFunder != nil:
+		return decodeAndHandle(ctx, line, h.OnFunder)
+	case entityType == PublishersFileEntityType && h.OnPublisher != nil:
+		return decodeAndHandle(ctx, line, h.OnPublisher)
+	case entityType == TopicsFileEntityType && h.OnTopic != nil:
+		return decodeAndHandle(ctx, line, h.OnTopic)
+	case entityType == DomainsFileEntityType && h.OnDomain != nil:
+		return decodeAndHandle(ctx, line, h.OnDomain)
+	case h.OnEntity != nil:
+		entity, errEntity := NewEntity(entityType)
+		if errEntity != nil {
+			return errEntity
+		}
+		err = decodeLine(line, entity)
+		if err != nil {
+			return err
+		}
+		if work, ok := entity.(*Work); ok {
+			work.GenerateAbstractFromInvertedIndex()
+		}
+		return h.OnEntity(ctx, entityType, entity)
+	default:
+		return nil
+	}
+}
+
+// decodeAndHandle decodes the line into a new entity and passes it to the callback
+func decodeAndHandle[T any](ctx context.Context, line string, fn func(ctx context.Context, entity *T) error) error {
+	entity := new(T)
+	err := decodeLine(line, entity)
+	if err != nil {
+		return err
+	}
+	return fn(ctx, entity)
+}
+
+// lineBuffers reuses the buffers of the decoded lines
+var lineBuffers = sync.Pool{New: func() any { return new([]byte) }}
+
+// decodeLine decodes a json line with a pooled iterator and buffer
+func decodeLine(line string, v any) error {
+	buf := lineBuffers.Get().(*[]byte)
+	defer lineBuffers.Put(buf)
+	*buf = append((*buf)[:0], line...)
+	iter := json.BorrowIterator(*buf)
+	defer json.ReturnIterator(iter)
+	iter.ReadVal(v)
+	if iter.Error != nil && iter.Error != io.EOF {
+		return iter.Error
+	}
+	return nil
+}
+
+// TypedProcessor processes a directory like the Processor, but passes decoded entities to the TypedHandlers.
+// The LineHandler of the Processor is set by the TypedProcessor.
+type TypedProcessor struct {
+	Processor Processor
+	Handlers  TypedHandlers
+}
+
+// NewTypedProcessor creates a typed processor for the directory
+func NewTypedProcessor(directoryPath string, handlers TypedHandlers) *TypedProcessor {
+	return &TypedProcessor{
+		Processor: Processor{DirectoryPath: directoryPath},
+		Handlers:  handlers,
+	}
+}
+
+// ProcessDirectory processes all files of the directory
+func (tp *TypedProcessor) ProcessDirectory(ctx context.Context) (*RunReport, error) {
+	tp.Processor.LineHandler = tp.Handlers.LineHandler(ctx)
+	return tp.Processor.ProcessDirectoryContext(ctx)
+}
+
+// ProcessFiles processes the files
+func (tp *TypedProcessor) ProcessFiles(ctx context.Context, filePaths []string) (*RunReport, error) {
+	tp.Processor.LineHandler = tp.Handlers.LineHandler(ctx)
+	return tp.Processor.ProcessFilesContext(ctx, filePaths)
+}
+
+// ParseFile processes a single file and returns the number of lines
+func (tp *TypedProcessor) ParseFile(ctx context.Context, filePath string) (count int, err error) {
+	tp.Processor.LineHandler = tp.Handlers.LineHandler(ctx)
+	return tp.Processor.ParseFileContext(ctx, filePath)
+}
@@ -0,0 +1,87 @@
+package openalex
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestTypedProcessor(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]string{
+		"works/updated_date=2023-05-01/part_000.gz": {
+			`{"id":"https://openalex.org/W1","title":"First","abstract_inverted_index":{"Hello":[0],"world":[1]}}`,
+			`{"id":"https://openalex.org/W2","title":"Second"}`,
+		},
+		"authors/updated_date=2023-05-01/part_000.gz":  {`{"id":"https://openalex.org/A1","display_name":"Author"}`},
+		"concepts/updated_date=2023-05-01/part_000.gz": {`{"id":"https://openalex.org/C1","display_name":"Concept"}`},
+		"funders/updated_date=2023-05-01/part_000.gz":  {`{"id":"https://openalex.org/F1","display_name":"Funder"}`},
+	}
+	for filePath, lines := range files {
+		filePath = filepath.Join(dir, filepath.FromSlash(filePath))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, gzipBytes(t, lines...), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	var works []*Work
+	var authors []*Author
+	others := make(map[FileEntityType][]Entity)
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+	tp := NewTypedProcessor(dir, TypedHandlers{
+		OnWork: func(ctx context.Context, work *Work) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if ctx.Value(ctxKey{}) != "value" {
+				t.Error("expected the context of the run")
+			}
+			works = append(works, work)
+			return nil
+		},
+		OnAuthor: func(ctx context.Context, author *Author) error {
+			mu.Lock()
+			defer mu.Unlock()
+			authors = append(authors, author)
+			return nil
+		},
+		OnEntity: func(ctx context.Context, fileEntityType FileEntityType, entity Entity) error {
+			mu.Lock()
+			defer mu.Unlock()
+			others[fileEntityType] = append(others[fileEntityType], entity)
+			return nil
+		},
+	})
+	tp.Processor.Workers = 2
+	if _, err := tp.ProcessDirectory(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(works) != 2 || len(authors) != 1 || authors[0].ID != "https://openalex.org/A1" {
+		t.Fatal("unexpected entities", works, authors)
+	}
+	for _, work := range works {
+		if work.ID == "https://openalex.org/W1" && work.Abstract != "Hello world" {
+			t.Error("expected the abstract", work.Abstract)
+		}
+	}
+	if len(others[ConceptsFileEntityType]) != 1 || len(others[FundersFileEntityType]) != 1 ||
+		others[ConceptsFileEntityType][0].GetType() != string(ConceptsFileEntityType) {
+		t.Error("unexpected other entities", others)
+	}
+
+	// entity types without callbacks are skipped
+	h := TypedHandlers{}
+	if err := h.Handle(ctx, WorksFileEntityType, `not json`); err != nil {
+		t.Error("expected a skipped line", err)
+	}
+	h.OnWork = func(ctx context.Context, work *Work) error { return nil }
+	if err := h.Handle(ctx, WorksFileEntityType, `{"id":`); err == nil {
+		t.Error("expected a decoding error")
+	}
+}
@@ -0,0 +1,257 @@
+package openalex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+)
+
+// ErrRecordCountMismatch is returned when the number of records of a file does not match its manifest
+var ErrRecordCountMismatch = errors.New("record count does not match the manifest")
+
+// FileRecordCount contains the expected and the actual number of records of a part file
+type FileRecordCount struct {
+	FilePath    string         `json:"file_path"`
+	EntityType  FileEntityType `json:"entity_type"`
+	UpdatedDate string         `json:"updated_date"`
+	Expected    int            `json:"expected"`        // record_count of the manifest
+	Actual      int            `json:"actual"`          // number of records in the file
+	Error       string         `json:"error,omitempty"` // set if the file could not be read
+}
+
+// OK returns true if the file could be read and contains the expected number of records
+func (f *FileRecordCount) OK() bool {
+	return f.Error == "" && f.Expected == f.Actual
+}
+
+// EntityRecordCount sums up the record counts of the files of an entity type
+type EntityRecordCount struct {
+	EntityType FileEntityType `json:"entity_type"`
+	Files      int            `json:"files"`
+	Mismatches int            `json:"mismatches"` // files that could not be read or do not match
+	Expected   int            `json:"expected"`
+	Actual     int            `json:"actual"`
+}
+
+// RecordCountReport contains the record counts of every file that is listed in the manifests
+type RecordCountReport struct {
+	Files    []*FileRecordCount   `json:"files"`    // in manifest order
+	Entities []*EntityRecordCount `json:"entities"` // in the order of AllManifestUrls
+}
+
+// OK returns true if all files match their manifest
+func (r *RecordCountReport) OK() bool {
+	for _, e := range r.Entities {
+		if e.Mismatches > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Err returns ErrRecordCountMismatch if any file does not match its manifest
+func (r *RecordCountReport) Err() error {
+	mismatches := r.Mismatches()
+	if len(mismatches) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %d files, e.g. %s", ErrRecordCountMismatch, len(mismatches), mismatches[0].FilePath)
+}
+
+// Mismatches returns the files that could not be read or do not match their manifest
+func (r *RecordCountReport) Mismatches() (files []*FileRecordCount) {
+	for _, f := range r.Files {
+		if !f.OK() {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Print prints the mismatching files and a summary per entity type
+func (r *RecordCountReport) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	mismatches := r.Mismatches()
+	if len(mismatches) > 0 {
+		fmt.Fprintln(tw, "file\texpected\tactual\terror\t")
+		for _, f := range mismatches {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", f.FilePath, humanize.Comma(int64(f.Expected)), humanize.Comma(int64(f.Actual)), f.Error)
+		}
+		fmt.Fprintln(tw)
+	}
+	fmt.Fprintln(tw, "entity\tfiles\tmismatches\texpected\tactual\t")
+	for _, e := range r.Entities {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t\n", e.EntityType, e.Files, e.Mismatches, humanize.Comma(int64(e.Expected)), humanize.Comma(int64(e.Actual)))
+	}
+	return tw.Flush()
+}
+
+// VerifyRecordCounts counts the records of every file that is listed in the manifests of the data directory
+// and compares them with the record_count of the manifests.
+// The data directory contains the entity directories, e.g. /openalex/data.
+// The files are counted by workers in parallel and all files are checked, even if a file does not match.
+func VerifyRecordCounts(dataDir string, workers int) (report *RecordCountReport, err error) {
+	manifests, err := ReadManifestsFromDirectory(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []ManifestEntry
+	for _, manifestUrl := range AllManifestUrls {
+		entityType, errType := GetEntityType(manifestUrl.Key())
+		if errType != nil {
+			return nil, errType
+		}
+		if manifest, ok := manifests[entityType]; ok {
+			entries = append(entries, manifest.Entries...)
+		}
+	}
+	return verifyRecordCounts(entries, dataDir, workers)
+}
+
+// VerifyRecordCounts counts the records of every file of the manifest, see VerifyRecordCounts
+func (m *Manifest) VerifyRecordCounts(dataDir string, workers int) (report *RecordCountReport, err error) {
+	return verifyRecordCounts(m.Entries, dataDir, workers)
+}
+
+// VerifyRecordCounts counts the records of the local part files against the local manifests.
+// Only the entity types and partitions selected by the sync options are verified.
+func (d *SnapshotDownloader) VerifyRecordCounts() (report *RecordCountReport, err error) {
+	dataDir := d.localPath("data")
+	manifests, err := ReadManifestsFromDirectory(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []ManifestEntry
+	for _, manifestUrl := range AllManifestUrls {
+		entityType, errType := GetEntityType(manifestUrl.Key())
+		if errType != nil {
+			return nil, errType
+		}
+		manifest, ok := manifests[entityType]
+		if !ok || !d.Options.IncludesEntityType(entityType) {
+			continue
+		}
+		for _, entry := range manifest.Entries {
+			if d.Options.IncludesUpdatedDate(entry.UpdatedDate()) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return verifyRecordCounts(entries, dataDir, d.Workers)
+}
+
+// verifyRecordCounts counts the records of the entries in parallel
+func verifyRecordCounts(entries []ManifestEntry, dataDir string, workers int) (report *RecordCountReport, err error) {
+	logger := slog.With("dataDir", dataDir)
+	logger.With("files", len(entries)).Info("Start verifying record counts")
+	report = &RecordCountReport{Files: make([]*FileRecordCount, len(entries))}
+	for i := range entries {
+		entityType, errType := entries[i].EntityType()
+		if errType != nil {
+			return nil, errType
+		}
+		report.Files[i] = &FileRecordCount{
+			FilePath:    entries[i].LocalPath(dataDir),
+			EntityType:  entityType,
+			UpdatedDate: entries[i].UpdatedDate(),
+			Expected:    entries[i].Meta.RecordCount,
+		}
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	queue := make(chan *FileRecordCount)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range queue {
+				count, errCount := CountRecords(f.FilePath)
+				f.Actual = count
+				if errCount != nil {
+					f.Error = errCount.Error()
+				}
+			}
+		}()
+	}
+	for _, f := range report.Files {
+		queue <- f
+	}
+	close(queue)
+	wg.Wait()
+
+	// sum up per entity type
+	index := make(map[FileEntityType]*EntityRecordCount)
+	for _, f := range report.Files {
+		e, ok := index[f.EntityType]
+		if !ok {
+			e = &EntityRecordCount{EntityType: f.EntityType}
+			index[f.EntityType] = e
+			report.Entities = append(report.Entities, e)
+		}
+		e.Files++
+		e.Expected += f.Expected
+		e.Actual += f.Actual
+		if !f.OK() {
+			e.Mismatches++
+			logger.
+				With("filePath", f.FilePath).
+				With("expected", f.Expected).
+				With("actual", f.Actual).
+				With("err", f.Error).
+				Warn("Record count does not match")
+		}
+	}
+	logger.With("mismatches", len(report.Mismatches())).Info("Finished verifying record counts")
+	return report, nil
+}
+
+// CountRecords counts the lines of a part file without parsing them.
+// Files with a .gz extension are decompressed.
+func CountRecords(filePath string) (count int, err error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if strings.HasSuffix(filePath, ".gz") {
+		gzipReader, errGzip := gzip.NewReader(file)
+		if errGzip != nil {
+			return 0, errGzip
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+	buf := make([]byte, 256*1024)
+	var last byte = '\n'
+	for {
+		n, errRead := reader.Read(buf)
+		if n > 0 {
+			count += bytes.Count(buf[:n], []byte{'\n'})
+			last = buf[n-1]
+		}
+		if errors.Is(errRead, io.EOF) {
+			break
+		}
+		if errRead != nil {
+			return count, errRead
+		}
+	}
+	// the last line does not need to end with a new line
+	if last != '\n' {
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,100 @@
+package openalex
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyRecordCounts(t *testing.T) {
+	destPath := t.TempDir()
+	dataDir := filepath.Join(destPath, "data")
+	files := map[string][]byte{
+		"works/manifest": []byte(`{"entries": [
+			{"url": "s3://openalex/data/works/updated_date=2023-05-01/part_000.gz", "meta": {"content_length": 1, "record_count": 2}},
+			{"url": "s3://openalex/data/works/updated_date=2023-05-02/part_000.gz", "meta": {"content_length": 1, "record_count": 3}},
+			{"url": "s3://openalex/data/works/updated_date=2023-05-03/part_000.gz", "meta": {"content_length": 1, "record_count": 1}}
+		]}`),
+		"works/updated_date=2023-05-01/part_000.gz": gzipBytes(t, `{"id":"W1"}`, `{"id":"W2"}`),
+		"works/updated_date=2023-05-02/part_000.gz": gzipBytes(t, `{"id":"W3"}`, `{"id":"W4"}`),
+		"authors/manifest": []byte(`{"entries": [
+			{"url": "s3://openalex/data/authors/updated_date=2023-05-01/part_000.gz", "meta": {"content_length": 1, "record_count": 1}}
+		]}`),
+		"authors/updated_date=2023-05-01/part_000.gz": gzipBytes(t, `{"id":"A1"}`),
+	}
+	for name, data := range files {
+		path := filepath.Join(dataDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := VerifyRecordCounts(dataDir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || !errors.Is(report.Err(), ErrRecordCountMismatch) {
+		t.Error("expected mismatches", report.Err())
+	}
+	if len(report.Files) != 4 || len(report.Entities) != 2 {
+		t.Fatal("unexpected report", len(report.Files), len(report.Entities))
+	}
+	// every file is checked, not only the first mismatch
+	mismatches := report.Mismatches()
+	if len(mismatches) != 2 || mismatches[0].Expected != 3 || mismatches[0].Actual != 2 || mismatches[1].Error == "" {
+		t.Error("unexpected mismatches", mismatches)
+	}
+	authors, works := report.Entities[0], report.Entities[1]
+	if authors.EntityType != AuthorsFileEntityType || authors.Mismatches != 0 || authors.Actual != 1 {
+		t.Error("unexpected authors", authors)
+	}
+	if works.EntityType != WorksFileEntityType || works.Files != 3 || works.Mismatches != 2 || works.Expected != 6 || works.Actual != 4 {
+		t.Error("unexpected works", works)
+	}
+	var buf bytes.Buffer
+	if err = report.Print(&buf); err != nil || !strings.Contains(buf.String(), "updated_date=2023-05-02") {
+		t.Error("unexpected output", buf.String(), err)
+	}
+
+	// the partitions selected by the sync options match
+	d := NewSnapshotDownloader(destPath)
+	d.Options = SyncOptions{UpdatedDateTo: "2023-05-01"}
+	report, err = d.VerifyRecordCounts()
+	if err != nil || !report.OK() || len(report.Files) != 2 {
+		t.Error("expected matching files", report, err)
+	}
+
+	manifest, err := ReadManifestFromFile(filepath.Join(dataDir, "works", "manifest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = manifest.CompareData(dataDir, nil); !errors.Is(err, ErrRecordCountMismatch) {
+		t.Error("expected mismatch", err)
+	}
+}
+
+func TestCountRecords(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "part_000")
+	if err := os.WriteFile(plain, []byte("{}\n{}\n{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	count, err := CountRecords(plain)
+	if err != nil || count != 3 {
+		t.Error("unexpected count", count, err)
+	}
+	compressed := filepath.Join(dir, "part_000.gz")
+	if err = os.WriteFile(compressed, gzipBytes(t, "{}", "{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	count, err = CountRecords(compressed)
+	if err != nil || count != 2 {
+		t.Error("unexpected count", count, err)
+	}
+}
@@ -0,0 +1,238 @@
+package openalex
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileVerificationReport contains the result of the verification of the local part files
+type FileVerificationReport struct {
+	Verified     int      // number of files that passed the verification
+	Missing      []string // files that are listed in a manifest but do not exist
+	Truncated    []string // files that are smaller than the content_length of the manifest
+	Corrupt      []string // files that are larger than the content_length or are no valid gzip files
+	Unexpected   []string // files in a partition directory that are not listed in any manifest
+	Redownloaded []string // files that were downloaded again
+}
+
+// OK returns true if all files passed the verification or were downloaded again
+func (r *FileVerificationReport) OK() bool {
+	bad := len(r.Missing) + len(r.Truncated) + len(r.Corrupt)
+	return len(r.Unexpected) == 0 && bad == len(r.Redownloaded)
+}
+
+// fileVerification is a file that needs to be verified
+type fileVerification struct {
+	job    downloadJob
+	result *[]string // the list of the report the file belongs to, nil if the file is valid
+}
+
+// VerifyFiles checks every local part file against the content_length of the local manifests
+// and decompresses it completely to validate the gzip checksum.
+// Only the entity types and partitions selected by the sync options are verified.
+// If redownload is true, missing, truncated and corrupt files are downloaded again and verified once more,
+// only the valid ones are listed in Redownloaded.
+// With a StateHandler, files that were deleted after they were processed are skipped, like in Plan.
+// Merged ids files are not verified, as they are not listed in any manifest.
+func (d *SnapshotDownloader) VerifyFiles(ctx context.Context, redownload bool) (report *FileVerificationReport, err error) {
+	logger := slog.With("destPath", d.DestPath)
+	logger.Info("Start verifying files")
+	report = &FileVerificationReport{}
+
+	var verifications []*fileVerification
+	for _, manifestUrl := range AllManifestUrls {
+		if err = ctx.Err(); err != nil {
+			return report, err
+		}
+		entityType, errType := GetEntityType(manifestUrl.Key())
+		if errType != nil || !d.Options.IncludesEntityType(entityType) {
+			continue
+		}
+		manifestPath := d.localPath(manifestUrl.Key())
+		if _, errStat := os.Stat(manifestPath); errors.Is(errStat, os.ErrNotExist) {
+			logger.With("manifestPath", manifestPath).Warn("Skipping entity without local manifest")
+			continue
+		}
+		manifest, errRead := ReadManifestFromFile(manifestPath)
+		if errRead != nil {
+			return report, errRead
+		}
+
+		expected := make(map[string]struct{}, len(manifest.Entries))
+		for _, entry := range manifest.Entries {
+			key := entry.Key()
+			localPath := d.localPath(key)
+			expected[localPath] = struct{}{}
+			if !d.Options.includesFile(entityType, localPath) {
+				continue
+			}
+			if d.isProcessedAndDeleted(localPath) {
+				continue
+			}
+			verifications = append(verifications, &fileVerification{
+				job: downloadJob{key: key, localPath: localPath, size: int64(entry.Meta.ContentLength)},
+			})
+		}
+
+		// find the files that are not listed in the manifest
+		err = filepath.Walk(filepath.Dir(manifestPath), func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if errCtx := ctx.Err(); errCtx != nil {
+				return errCtx
+			}
+			if info.IsDir() || getUpdatedDate(path) == "" || isPartialPath(path) || !d.Options.includesFile(entityType, path) {
+				return nil
+			}
+			if _, ok := expected[path]; !ok {
+				report.Unexpected = append(report.Unexpected, path)
+			}
+			return nil
+		})
+		if err != nil {
+			logger.With("err", err).Error("error while walking the entity directory")
+			return report, err
+		}
+	}
+
+	// verify the files in parallel
+	workers := d.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	queue := make(chan *fileVerification)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for v := range queue {
+				if ctx.Err() != nil {
+					continue
+				}
+				v.result = report.classify(ctx, v.job.localPath, v.job.size)
+			}
+		}()
+	}
+	for _, v := range verifications {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- v
+	}
+	close(queue)
+	wg.Wait()
+	if err = ctx.Err(); err != nil {
+		// the files that were not verified must not be counted as verified
+		logger.With("err", err).Warn("Verification stopped")
+		return report, err
+	}
+
+	var jobs []*downloadJob
+	for _, v := range verifications {
+		if v.result == nil {
+			report.Verified++
+			continue
+		}
+		*v.result = append(*v.result, v.job.localPath)
+		if redownload {
+			job := v.job
+			jobs = append(jobs, &job)
+		}
+	}
+
+	if len(jobs) > 0 {
+		sizes := make(map[string]int64, len(jobs))
+		for _, job := range jobs {
+			// a corrupt file must not be resumed
+			removePartial(partialPath(job.localPath))
+			_ = os.Remove(job.localPath)
+			sizes[job.localPath] = job.size
+		}
+		downloaded, errDownload := d.downloadAll(ctx, jobs)
+		// the downloaded files are verified again, the files that are still bad stay in their list
+		for _, localPath := range downloaded {
+			if report.classify(ctx, localPath, sizes[localPath]) != nil {
+				logger.With("localPath", localPath).Warn("Downloaded file is still invalid")
+				continue
+			}
+			report.Redownloaded = append(report.Redownloaded, localPath)
+		}
+		if errDownload != nil {
+			logger.With("err", errDownload).Error("error while downloading files again")
+			return report, errDownload
+		}
+		if err = ctx.Err(); err != nil {
+			return report, err
+		}
+	}
+
+	logger.
+		With("verified", report.Verified).
+		With("missing", len(report.Missing)).
+		With("truncated", len(report.Truncated)).
+		With("corrupt", len(report.Corrupt)).
+		With("unexpected", len(report.Unexpected)).
+		Info("Finished verifying files")
+	return report, nil
+}
+
+// classify returns the list of the report the file belongs to, or nil if the file is valid
+func (r *FileVerificationReport) classify(ctx context.Context, localPath string, size int64) *[]string {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return &r.Missing
+	}
+	if info.Size() < size {
+		return &r.Truncated
+	}
+	if info.Size() > size {
+		return &r.Corrupt
+	}
+	err = verifyGzip(ctx, localPath)
+	if err != nil {
+		slog.With("err", err).With("localPath", localPath).Warn("Invalid gzip file")
+		return &r.Corrupt
+	}
+	return nil
+}
+
+// verifyGzip decompresses the file completely, which validates the checksum and the size of the trailer.
+// It stops when the context is cancelled.
+func verifyGzip(ctx context.Context, filePath string) (err error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(contextReader{ctx: ctx, reader: file})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, reader)
+	if err != nil {
+		return err
+	}
+	return reader.Close()
+}
+
+// contextReader is a reader that fails with the error of the context when it is cancelled
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+// Read reads from the underlying reader if the context is not cancelled
+func (r contextReader) Read(p []byte) (n int, err error) {
+	if err = r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
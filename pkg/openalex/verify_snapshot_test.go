@@ -0,0 +1,169 @@
+package openalex
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotDownloaderVerifyFiles(t *testing.T) {
+	objects := map[string][]byte{
+		"data/works/updated_date=2023-05-16/part_000.gz": gzipBytes(t, `{"id":"W1"}`),
+		"data/works/updated_date=2023-05-16/part_001.gz": gzipBytes(t, `{"id":"W2"}`),
+		"data/works/updated_date=2023-05-16/part_002.gz": gzipBytes(t, `{"id":"W3"}`),
+		"data/works/updated_date=2023-05-16/part_003.gz": gzipBytes(t, `{"id":"W4"}`),
+	}
+	server := newTestBucket(t, objects)
+	destPath := t.TempDir()
+	d := NewSnapshotDownloader(destPath)
+	d.BaseUrl = server.URL
+	err := d.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	partitionDir := filepath.Join(destPath, "data", "works", "updated_date=2023-05-16")
+	// missing
+	err = os.Remove(filepath.Join(partitionDir, "part_000.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// truncated
+	err = os.Truncate(filepath.Join(partitionDir, "part_001.gz"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// corrupt, same size but a broken checksum
+	data := objects["data/works/updated_date=2023-05-16/part_002.gz"]
+	corrupt := append([]byte{}, data...)
+	corrupt[len(corrupt)-8] ^= 0xff
+	err = os.WriteFile(filepath.Join(partitionDir, "part_002.gz"), corrupt, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unexpected
+	err = os.WriteFile(filepath.Join(partitionDir, "part_999.gz"), data, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unfinished downloads are not unexpected
+	err = os.WriteFile(filepath.Join(partitionDir, "part_998.partial"), data[:5], 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := d.VerifyFiles(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Verified != 1 || len(report.Missing) != 1 || len(report.Truncated) != 1 || len(report.Corrupt) != 1 || len(report.Unexpected) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.OK() {
+		t.Error("report should not be ok")
+	}
+
+	// download the bad files again
+	err = os.Remove(filepath.Join(partitionDir, "part_999.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = d.VerifyFiles(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Redownloaded) != 3 || !report.OK() {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	report, err = d.VerifyFiles(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Verified != 4 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestSnapshotDownloaderVerifyFilesRecheck(t *testing.T) {
+	key := "data/works/updated_date=2023-05-16/part_000.gz"
+	processedKey := "data/works/updated_date=2023-05-16/part_001.gz"
+	data := gzipBytes(t, `{"id":"W1"}`)
+	objects := map[string][]byte{
+		key:          data,
+		processedKey: gzipBytes(t, `{"id":"W2"}`),
+	}
+	server := newTestBucket(t, objects)
+	destPath := t.TempDir()
+	d := NewSnapshotDownloader(destPath)
+	d.BaseUrl = server.URL
+	d.StateHandler = NewStateHandler("state.db", t.TempDir(), destPath)
+	err := d.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a processed file that was deleted locally is not missing
+	processedPath := d.localPath(processedKey)
+	_, err = d.StateHandler.RegisterOrSkipEntityFile(processedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.StateHandler.MarkEntityFileAsFinished()
+	err = os.Remove(processedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the local file and the remote object are corrupt
+	corrupt := append([]byte{}, data...)
+	corrupt[len(corrupt)-8] ^= 0xff
+	objects[key] = corrupt
+	err = os.WriteFile(d.localPath(key), corrupt, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := d.VerifyFiles(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Missing) != 0 || len(report.Corrupt) != 1 || len(report.Redownloaded) != 0 || report.OK() {
+		t.Errorf("expected a corrupt file after the download %+v", report)
+	}
+	if _, err = os.Stat(processedPath); !os.IsNotExist(err) {
+		t.Error("the processed file must not be downloaded again")
+	}
+}
+
+func TestSnapshotDownloaderVerifyFilesCancel(t *testing.T) {
+	objects := map[string][]byte{
+		"data/works/updated_date=2023-05-16/part_000.gz": gzipBytes(t, `{"id":"W1"}`),
+		"data/works/updated_date=2023-05-16/part_001.gz": gzipBytes(t, `{"id":"W2"}`),
+	}
+	server := newTestBucket(t, objects)
+	destPath := t.TempDir()
+	d := NewSnapshotDownloader(destPath)
+	d.BaseUrl = server.URL
+	err := d.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err := d.VerifyFiles(ctx, true)
+	if !errors.Is(err, context.Canceled) {
+		t.Error("expected a cancelled verification", err)
+	}
+	if report.Verified != 0 || len(report.Redownloaded) != 0 {
+		t.Errorf("expected no verified files %+v", report)
+	}
+
+	// a cancelled gzip check stops before the end of the file
+	err = verifyGzip(ctx, filepath.Join(destPath, "data", "works", "updated_date=2023-05-16", "part_000.gz"))
+	if !errors.Is(err, context.Canceled) {
+		t.Error("expected a cancelled gzip check", err)
+	}
+}